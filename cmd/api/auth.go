@@ -1,6 +1,10 @@
 package main
 
 import (
+	"backend/internal/keyring"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
@@ -13,7 +17,7 @@ import (
 type auth struct {
 	Issuer        string
 	Audience      string
-	Secret        string
+	Keys          *keyring.Keyring
 	TokenExpiry   time.Duration
 	RefreshExpiry time.Duration
 	CookieDomain  string
@@ -25,6 +29,7 @@ type jwtUser struct {
 	Id        int    `json:"id"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
+	Role      string `json:"role"`
 }
 
 type tokenPairs struct {
@@ -33,6 +38,7 @@ type tokenPairs struct {
 }
 
 type claims struct {
+	Role string `json:"role"`
 	jwt.RegisteredClaims
 }
 
@@ -49,12 +55,13 @@ func (j *auth) GenerateTokenPair(user *jwtUser) (tokenPairs, error) {
 	claims["iss"] = j.Issuer
 	claims["iat"] = time.Now().UTC().Unix() // Unix timestamp
 	claims["typ"] = "JWT"
+	claims["role"] = user.Role
 
 	// Set the expiry for JWT
 	claims["exp"] = time.Now().UTC().Add(j.TokenExpiry).Unix()
 
-	// Create signed JWT token
-	signedAccessToken, err := token.SignedString([]byte(j.Secret))
+	// Create signed JWT token, with the current key
+	signedAccessToken, err := j.Keys.Sign(token)
 	if err != nil {
 		return tokenPairs{}, err
 	}
@@ -65,11 +72,18 @@ func (j *auth) GenerateTokenPair(user *jwtUser) (tokenPairs, error) {
 	refreshTokenClaims["sub"] = fmt.Sprint(user.Id)
 	refreshTokenClaims["iat"] = time.Now().UTC().Unix()
 
+	// a unique ID, so no two refresh tokens (or their hashes) are the same
+	jti, err := randomToken()
+	if err != nil {
+		return tokenPairs{}, err
+	}
+	refreshTokenClaims["jti"] = jti
+
 	// Set expiry for refresh
 	refreshTokenClaims["exp"] = time.Now().UTC().Add(j.RefreshExpiry).Unix()
 
 	// Create signed refresh token
-	signedRefreshToken, err := refreshToken.SignedString([]byte(j.Secret))
+	signedRefreshToken, err := j.Keys.Sign(refreshToken)
 	if err != nil {
 		return tokenPairs{}, err
 	}
@@ -137,15 +151,9 @@ func (j *auth) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Reques
 	// declare empty claims
 	claims := &claims{}
 
-	// parse token into claims
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
-		// validate signing algorithm. Miss this and it's a vulnerability
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(j.Secret), nil
-	})
+	// parse token into claims. The keyring picks the key by kid and
+	// validates the signing algorithm
+	_, err := jwt.ParseWithClaims(token, claims, j.Keys.Keyfunc)
 
 	if err != nil {
 		if strings.HasPrefix(err.Error(), "token is expired by") {
@@ -162,4 +170,22 @@ func (j *auth) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Reques
 
 	// Valid, non-expired token, that we issues
 	return token, claims, nil
-}
\ No newline at end of file
+}
+
+// randomToken returns 16 random bytes, hex encoded
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the SHA-256 of a token. Only hashes of refresh tokens
+// are stored, so a leaked table can't be used to mint sessions.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"database/sql"
-	"log"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -29,7 +28,7 @@ func (app *application) connectToDb() (*sql.DB, error) {
 		return nil, err
 	}
 
-	log.Println("Connected to Postgres")
+	app.Logger.Info("connected to Postgres")
 
 	return conn, nil
 }
\ No newline at end of file
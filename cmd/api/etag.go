@@ -0,0 +1,99 @@
+package main
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// movieEtag is the entity tag of a movie as editors see it. It changes with
+// the version of the movie, which every write increments.
+func movieEtag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatch checks the If-Match header of a write against the current version
+// of a movie. Writes without the header are refused, so that editors cannot
+// overwrite changes they have not seen; writes at another version get the
+// current movie back.
+func (app *application) ifMatch(w http.ResponseWriter, r *http.Request, movie *models.Movie) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		app.errorJson(w, errors.New("If-Match header is required"), http.StatusPreconditionRequired)
+		return false
+	}
+
+	current := movieEtag(movie.Version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		// If-Match compares strongly, so weak tags never match
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+
+	app.movieConflict(w, r, movie.Id)
+	return false
+}
+
+// movieConflict responds 412 with the current movie, so that the editor can
+// reapply their change on top of it
+func (app *application) movieConflict(w http.ResponseWriter, r *http.Request, id int) {
+	app.writeMovieForEdit(w, r, http.StatusPreconditionFailed, id)
+}
+
+// writeMovieForEdit responds with a movie and all genres, tagged with the
+// version of the movie
+func (app *application) writeMovieForEdit(w http.ResponseWriter, r *http.Request, status int, id int) {
+	movie, allGenres, err := app.Db.OneMovieForEdit(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJson(w, errors.New("movie not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	var payload = struct {
+		Movie  *models.Movie   `json:"movie"`
+		Genres []*models.Genre `json:"genres"`
+	}{
+		movie,
+		allGenres,
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieEtag(movie.Version))
+
+	_ = app.writeJson(w, status, payload, headers)
+}
+
+func (app *application) movieFromUrl(w http.ResponseWriter, r *http.Request) (*models.Movie, bool) {
+	movieId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return nil, false
+	}
+
+	movie, err := app.Db.OneMovie(movieId)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJson(w, errors.New("movie not found"), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return nil, false
+	}
+
+	return movie, true
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository/dbrepo"
+	"net/http"
+	"testing"
+)
+
+// movieForEdit is the body of GET /admin/movies/{id}, and of a 412
+type movieForEdit struct {
+	Movie  models.Movie    `json:"movie"`
+	Genres []*models.Genre `json:"genres"`
+}
+
+// getMovieForEdit reads a movie as an editor does, and returns it with
+// its ETag
+func getMovieForEdit(t *testing.T, app *application, token string) (models.Movie, string) {
+	t.Helper()
+
+	resp := serve(t, app, http.MethodGet, "/admin/movies/1", nil, bearer(token))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /admin/movies/1: status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var body movieForEdit
+	readJson(t, resp, &body)
+
+	// the frontend sends the genres back as ids
+	for _, g := range body.Movie.Genres {
+		body.Movie.GenresArray = append(body.Movie.GenresArray, g.Id)
+	}
+
+	return body.Movie, resp.Header.Get("ETag")
+}
+
+// updateMovie sends a movie back with a PATCH, with If-Match if etag is set
+func updateMovie(t *testing.T, app *application, token string, movie models.Movie, etag string) *http.Response {
+	t.Helper()
+
+	headers := bearer(token)
+	if etag != "" {
+		headers.Set("If-Match", etag)
+	}
+
+	return serve(t, app, http.MethodPatch, "/admin/movies/1", movie, headers)
+}
+
+func TestMovieForEditEtag(t *testing.T) {
+	app := newTestApp(t, dbrepo.NewMemoryDbRepo())
+	token, _ := login(t, app)
+
+	movie, etag := getMovieForEdit(t, app, token)
+	if etag != `"1"` {
+		t.Errorf("ETag = %s, want \"1\"", etag)
+	}
+	if movie.Version != 1 {
+		t.Errorf("version = %d, want 1", movie.Version)
+	}
+}
+
+func TestUpdateMovieWithoutIfMatch(t *testing.T) {
+	app := newTestApp(t, dbrepo.NewMemoryDbRepo())
+	token, _ := login(t, app)
+
+	movie, _ := getMovieForEdit(t, app, token)
+	title := movie.Title
+	movie.Title = "Highlander II"
+
+	resp := updateMovie(t, app, token, movie, "")
+	if resp.StatusCode != http.StatusPreconditionRequired {
+		t.Fatalf("PATCH without If-Match: status %d, want %d", resp.StatusCode, http.StatusPreconditionRequired)
+	}
+
+	movie, etag := getMovieForEdit(t, app, token)
+	if movie.Title != title || etag != `"1"` {
+		t.Errorf("movie is %q at %s after a refused update, want %q at \"1\"", movie.Title, etag, title)
+	}
+}
+
+func TestUpdateMovieStaleVersion(t *testing.T) {
+	app := newTestApp(t, dbrepo.NewMemoryDbRepo())
+	token, _ := login(t, app)
+
+	// two editors read the movie
+	mine, etag := getMovieForEdit(t, app, token)
+	theirs := mine
+
+	theirs.Title = "Highlander: The Director's Cut"
+	resp := updateMovie(t, app, token, theirs, etag)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("first update: status %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	updated := resp.Header.Get("ETag")
+	if updated == "" || updated == etag {
+		t.Errorf("ETag after the first update = %q, want a new one", updated)
+	}
+
+	// the second is refused, and gets the movie as it is now
+	mine.Title = "Highlander II"
+	resp = updateMovie(t, app, token, mine, etag)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("update at a stale version: status %d, want %d", resp.StatusCode, http.StatusPreconditionFailed)
+	}
+	if resp.Header.Get("ETag") != updated {
+		t.Errorf("ETag of the 412 = %s, want %s", resp.Header.Get("ETag"), updated)
+	}
+
+	var current movieForEdit
+	readJson(t, resp, &current)
+	if current.Movie.Title != theirs.Title || movieEtag(current.Movie.Version) != updated {
+		t.Errorf("412 has %q at version %d, want %q at %s", current.Movie.Title, current.Movie.Version, theirs.Title, updated)
+	}
+	if len(current.Genres) == 0 {
+		t.Error("412 has no genres to edit with")
+	}
+
+	// deleting at the stale version is refused the same way
+	headers := bearer(token)
+	headers.Set("If-Match", etag)
+	resp = serve(t, app, http.MethodDelete, "/admin/movies/1", nil, headers)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("delete at a stale version: status %d, want %d", resp.StatusCode, http.StatusPreconditionFailed)
+	}
+}
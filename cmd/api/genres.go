@@ -0,0 +1,181 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/validator"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// InsertGenre adds a genre:
+//
+//	{"genre": "Western"}
+func (app *application) InsertGenre(w http.ResponseWriter, r *http.Request) {
+	genre, ok := app.readGenre(w, r)
+	if !ok {
+		return
+	}
+
+	genre.CreatedAt = time.Now()
+	genre.UpdatedAt = time.Now()
+
+	newId, err := app.Db.InsertGenre(*genre)
+	if errors.Is(err, models.ErrDuplicateGenre) {
+		app.failedValidation(w, map[string]string{"genre": err.Error()})
+		return
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+	genre.Id = newId
+
+	_ = app.writeJson(w, http.StatusCreated, genre)
+}
+
+// UpdateGenre renames a genre
+func (app *application) UpdateGenre(w http.ResponseWriter, r *http.Request) {
+	existing, ok := app.genreFromUrl(w, r, "id")
+	if !ok {
+		return
+	}
+
+	genre, ok := app.readGenre(w, r)
+	if !ok {
+		return
+	}
+
+	existing.Genre = genre.Genre
+	existing.UpdatedAt = time.Now()
+
+	err := app.Db.UpdateGenre(*existing)
+	if errors.Is(err, models.ErrDuplicateGenre) {
+		app.failedValidation(w, map[string]string{"genre": err.Error()})
+		return
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	_ = app.writeJson(w, http.StatusOK, existing)
+}
+
+// DeleteGenre removes a genre. Its movies stay, without the genre.
+func (app *application) DeleteGenre(w http.ResponseWriter, r *http.Request) {
+	genre, ok := app.genreFromUrl(w, r, "id")
+	if !ok {
+		return
+	}
+
+	err := app.Db.DeleteGenre(genre.Id)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "genre deleted",
+	}
+	_ = app.writeJson(w, http.StatusAccepted, resp)
+}
+
+// MergeGenres moves the movies of the genre in the URL to another genre,
+// then deletes it:
+//
+//	{"into": 5}
+func (app *application) MergeGenres(w http.ResponseWriter, r *http.Request) {
+	from, ok := app.genreFromUrl(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Into int `json:"into"`
+	}
+	err := app.readJson(w, r, &payload)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	if payload.Into == from.Id {
+		app.failedValidation(w, map[string]string{"into": "must be another genre"})
+		return
+	}
+
+	err = app.Db.MergeGenres(from.Id, payload.Into)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.failedValidation(w, map[string]string{"into": "genre does not exist"})
+		return
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "genres merged",
+	}
+	_ = app.writeJson(w, http.StatusAccepted, resp)
+}
+
+// genreFromUrl loads the genre whose id is in the URL parameter, or
+// responds with an error and returns false
+func (app *application) genreFromUrl(w http.ResponseWriter, r *http.Request, param string) (*models.Genre, bool) {
+	genreId, err := strconv.Atoi(chi.URLParam(r, param))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return nil, false
+	}
+
+	genre, err := app.Db.OneGenre(genreId)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJson(w, errors.New("genre not found"), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return nil, false
+	}
+
+	return genre, true
+}
+
+func (app *application) readGenre(w http.ResponseWriter, r *http.Request) (*models.Genre, bool) {
+	var payload struct {
+		Genre string `json:"genre"`
+	}
+
+	err := app.readJson(w, r, &payload)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return nil, false
+	}
+
+	genre := &models.Genre{Genre: payload.Genre}
+
+	v := validator.New()
+	models.ValidateGenre(v, genre)
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
+		return nil, false
+	}
+
+	return genre, true
+}
@@ -1,21 +1,34 @@
 package main
 
 import (
+	"backend/internal/config"
 	"backend/internal/graph"
+	"backend/internal/mailer"
 	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/validator"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
 )
 
+const graphQlResponseType = "application/graphql-response+json"
+
 func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 	var payload = struct {
 		Status  string `json:"status"`
@@ -31,14 +44,74 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) AllMovies(w http.ResponseWriter, r *http.Request) {
-	movies, err := app.Db.AllMovies()
+	q, err := readMovieQuery(r)
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
 
-	_ = app.writeJson(w, http.StatusOK, movies)
+	page, err := app.Db.FilterMovies(q)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	var payload = struct {
+		Movies []*models.Movie `json:"movies"`
+		Total  int             `json:"total"`
+		Limit  int             `json:"limit"`
+		Page   int             `json:"page,omitempty"`
+		Next   string          `json:"next,omitempty"`
+		Prev   string          `json:"prev,omitempty"`
+	}{
+		Movies: page.Movies,
+		Total:  page.Total,
+		Limit:  q.Limit,
+		Page:   q.Page,
+	}
+
+	// an empty page is still a list, not null
+	if payload.Movies == nil {
+		payload.Movies = []*models.Movie{}
+	}
+
+	if page.HasNext {
+		payload.Next = movieListLink(r, q, page, false)
+	}
+	if page.HasPrev {
+		payload.Prev = movieListLink(r, q, page, true)
+	}
+
+	_ = app.writeJson(w, http.StatusOK, payload)
+}
+
+func (app *application) SearchMovies(w http.ResponseWriter, r *http.Request) {
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+	if search == "" {
+		app.errorJson(w, errors.New("missing search query"))
+		return
+	}
+
+	limit, err := intParam(r.URL.Query(), "limit", defaultMovieLimit)
+	if err != nil || limit < 1 || limit > maxMovieLimit {
+		app.errorJson(w, fmt.Errorf("limit must be between 1 and %d", maxMovieLimit))
+		return
+	}
+
+	results, err := app.Db.SearchMovies(search, limit)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	if results == nil {
+		results = []*models.MovieSearchResult{}
+	}
+
+	_ = app.writeJson(w, http.StatusOK, results)
 }
 
 func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
@@ -49,106 +122,569 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	}
 	err := app.readJson(w, r, &requestPayload)
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
 
 	// Validate the user against DB
-	user, err := app.Db.GetUserByEmail(requestPayload.Email)
+	user, err := app.Db.GetUserByEmail(normalizeEmail(requestPayload.Email))
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
+		app.Metrics.login(false)
 		app.errorJson(w, errors.New("invalid credentials"))
 		return
 	}
 
 	// Check password
 	valid, err := user.PasswordMatches(requestPayload.Password)
+	if err != nil {
+		app.logError(r, err)
+	}
 	if err != nil || !valid {
-		fmt.Println(err)
+		app.Metrics.login(false)
 		app.errorJson(w, errors.New("invalid credentials"))
 		return
 	}
 
+	// Only verified accounts can log in
+	if user.EmailVerifiedAt == nil {
+		app.Metrics.login(false)
+		app.errorJson(w, errors.New("email address not verified"), http.StatusForbidden)
+		return
+	}
+
 	// Create JwtUser
 	u := jwtUser {
 		Id: user.Id,
 		FirstName: user.FirstName,
 		LastName: user.LastName,
+		Role: user.Role,
 	}
 
-	// Generate tokens
-	tokens, err := app.Auth.GenerateTokenPair(&u)
+	// Each login starts a new family of refresh tokens
+	familyId, err := randomToken()
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
 
-	// log.Println(tokens.Token)
-	refreshCookie := app.Auth.getRefreshCookie(tokens.RefreshToken)
-
-	http.SetCookie(w, refreshCookie)
+	// Generate tokens
+	tokens, err := app.issueTokens(w, &u, familyId)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
 
+	app.Metrics.login(true)
 	app.writeJson(w, http.StatusAccepted, tokens)
 }
 
 func (app *application) refreshToken(w http.ResponseWriter, r *http.Request) {
-	for _, cookie := range r.Cookies() {
-		if cookie.Name == app.Auth.CookieName {
-			claims := &claims{}
-			refreshToken := cookie.Value
-
-			// parse the token to get the claims
-			_, err := jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (any, error) {
-				return []byte(app.JwtSecret), nil
-			})
-			if err != nil {
-				app.errorJson(w, errors.New("unauthorized"), http.StatusUnauthorized)
-				return
-			}
+	cookie, err := r.Cookie(app.Auth.CookieName)
+	if err != nil {
+		app.errorJson(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
 
-			// get the user ID from the claim
-			userId, err := strconv.Atoi(claims.Subject)
-			if err != nil {
-				app.errorJson(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
+	claims := &claims{}
+	refreshToken := cookie.Value
 
-			user, err := app.Db.GetUserById(userId)
-			if err != nil {
-				app.errorJson(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
+	// parse the token to get the claims
+	_, err = jwt.ParseWithClaims(refreshToken, claims, app.Auth.Keys.Keyfunc)
+	if err != nil {
+		app.errorJson(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
 
-			u := jwtUser{
-				Id: user.Id,
-				FirstName: user.FirstName,
-				LastName: user.LastName,
-			}
+	// the token must be one we issued and haven't rotated or revoked yet
+	stored, err := app.Db.GetRefreshTokenByHash(hashToken(refreshToken))
+	if err != nil {
+		app.errorJson(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	if stored.RevokedAt != nil {
+		app.revokedRefreshTokenUsed(w, r, stored)
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		app.errorJson(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	// rotate: this token can't be used again. Losing the race to a
+	// concurrent refresh with the same token also counts as reuse.
+	revoked, err := app.Db.RevokeRefreshToken(stored.Id)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, errors.New("error generating tokens"), http.StatusUnauthorized)
+		return
+	}
+	if !revoked {
+		app.revokedRefreshTokenUsed(w, r, stored)
+		return
+	}
+
+	user, err := app.Db.GetUserById(stored.UserId)
+	if err != nil {
+		app.errorJson(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	u := jwtUser{
+		Id: user.Id,
+		FirstName: user.FirstName,
+		LastName: user.LastName,
+		Role: user.Role,
+	}
+
+	tokenPairs, err := app.issueTokens(w, &u, stored.FamilyId)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, errors.New("error generating tokens"), http.StatusUnauthorized)
+		return
+	}
+
+	app.writeJson(w, http.StatusOK, tokenPairs)
+}
+
+// revokedRefreshTokenUsed handles a refresh with a token that was already
+// rotated or revoked. Either it was stolen or the real client replayed it;
+// we can't tell which, so the whole family is revoked and both must log in.
+func (app *application) revokedRefreshTokenUsed(w http.ResponseWriter, r *http.Request, token *models.RefreshToken) {
+	err := app.Db.RevokeRefreshTokenFamily(token.FamilyId)
+	if err != nil {
+		app.logError(r, err)
+	}
 
-			tokenPairs, err := app.Auth.GenerateTokenPair(&u)
+	http.SetCookie(w, app.Auth.getExpiredRefreshCookie())
+	app.errorJson(w, errors.New("refresh token reuse detected"), http.StatusUnauthorized)
+}
+
+// issueTokens generates a token pair, stores the refresh token under the
+// given family and sets the refresh cookie
+func (app *application) issueTokens(w http.ResponseWriter, user *jwtUser, familyId string) (tokenPairs, error) {
+	tokens, err := app.Auth.GenerateTokenPair(user)
+	if err != nil {
+		return tokenPairs{}, err
+	}
+
+	now := time.Now()
+	err = app.Db.InsertRefreshToken(models.RefreshToken{
+		UserId:    user.Id,
+		TokenHash: hashToken(tokens.RefreshToken),
+		FamilyId:  familyId,
+		ExpiresAt: now.Add(app.Auth.RefreshExpiry),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return tokenPairs{}, err
+	}
+
+	http.SetCookie(w, app.Auth.getRefreshCookie(tokens.RefreshToken))
+
+	return tokens, nil
+}
+
+// jwks publishes the public keys that verify our tokens, for other services
+func (app *application) jwks(w http.ResponseWriter, r *http.Request) {
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	_ = app.writeJson(w, http.StatusOK, app.Auth.Keys.JWKS(), headers)
+}
+
+func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	// revoke the session server side, not just the cookie
+	cookie, err := r.Cookie(app.Auth.CookieName)
+	if err == nil {
+		stored, err := app.Db.GetRefreshTokenByHash(hashToken(cookie.Value))
+		if err == nil {
+			err = app.Db.RevokeRefreshTokenFamily(stored.FamilyId)
 			if err != nil {
-				app.errorJson(w, errors.New("error generating tokens"), http.StatusUnauthorized)
+				app.logError(r, err)
+				app.errorJson(w, err, http.StatusInternalServerError)
 				return
 			}
+		}
+	}
 
-			http.SetCookie(w, app.Auth.getRefreshCookie(tokenPairs.RefreshToken))
+	http.SetCookie(w, app.Auth.getExpiredRefreshCookie())
+	w.WriteHeader(http.StatusAccepted)
+}
 
-			app.writeJson(w, http.StatusOK, tokenPairs)
+func (app *application) register(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+	}
+	err := app.readJson(w, r, &requestPayload)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	requestPayload.Email = normalizeEmail(requestPayload.Email)
+
+	v := validator.New()
+	v.Check(validator.NotBlank(requestPayload.FirstName), "first_name", "must be provided")
+	v.Check(validator.MaxLength(requestPayload.FirstName, 255), "first_name", "must be at most 255 characters")
+	v.Check(validator.NotBlank(requestPayload.LastName), "last_name", "must be provided")
+	v.Check(validator.MaxLength(requestPayload.LastName, 255), "last_name", "must be at most 255 characters")
+	v.Check(validator.IsEmail(requestPayload.Email), "email", "must be a valid email address")
+	v.Check(validator.MaxLength(requestPayload.Email, 255), "email", "must be at most 255 characters")
+	validatePassword(v, requestPayload.Password)
+
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
+		return
+	}
+
+	// New users can view, and have to verify their email before logging in
+	user := models.User{
+		FirstName: strings.TrimSpace(requestPayload.FirstName),
+		LastName:  strings.TrimSpace(requestPayload.LastName),
+		Email:     requestPayload.Email,
+		Role:      models.RoleViewer,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	err = user.SetPassword(requestPayload.Password)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// A taken address gets the same answer as a new one, so that signing
+	// up can't be used to find out who has an account. The holder of the
+	// account is told by email instead.
+	user.Id, err = app.Db.InsertUser(user)
+	switch {
+	case errors.Is(err, models.ErrDuplicateEmail):
+		app.background(func() {
+			app.sendAccountExists(r, user.Email)
+		})
+	case err != nil:
+		app.logError(r, err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	default:
+		app.background(func() {
+			app.sendVerification(r, user)
+		})
+	}
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "account created, check your email to verify it",
+	}
+
+	_ = app.writeJson(w, http.StatusCreated, resp)
+}
+
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		app.errorJson(w, errors.New("missing token"))
+		return
+	}
+
+	userToken, err := app.Db.ConsumeUserToken(hashToken(token), models.ScopeVerification)
+	if err != nil {
+		app.errorJson(w, errors.New("invalid or expired token"))
+		return
+	}
+
+	err = app.Db.VerifyUser(userToken.UserId)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "email address verified",
+	}
+
+	_ = app.writeJson(w, http.StatusOK, resp)
+}
+
+// resendVerification emails a new verification link, for when the first one
+// expired or got lost
+func (app *application) resendVerification(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+	err := app.readJson(w, r, &requestPayload)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	email := normalizeEmail(requestPayload.Email)
+
+	v := validator.New()
+	v.Check(validator.IsEmail(email), "email", "must be a valid email address")
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
+		return
+	}
+
+	// As in forgotPassword, the answer doesn't say whether the address
+	// has an account, or whether it is verified already
+	app.background(func() {
+		user, err := app.Db.GetUserByEmail(email)
+		if err != nil || user.EmailVerifiedAt != nil {
+			return
 		}
+
+		app.sendVerification(r, *user)
+	})
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "if that address has an unverified account, a new link has been sent to it",
 	}
+
+	_ = app.writeJson(w, http.StatusAccepted, resp)
 }
 
-func (app *application) logout(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, app.Auth.getExpiredRefreshCookie())
-	w.WriteHeader(http.StatusAccepted)
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+	err := app.readJson(w, r, &requestPayload)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	email := normalizeEmail(requestPayload.Email)
+
+	v := validator.New()
+	v.Check(validator.IsEmail(email), "email", "must be a valid email address")
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
+		return
+	}
+
+	// The lookup happens in the background, so neither the response nor
+	// its timing gives away whether the address has an account
+	app.background(func() {
+		user, err := app.Db.GetUserByEmail(email)
+		if err != nil {
+			return
+		}
+
+		token, err := app.newUserToken(user.Id, models.ScopePasswordReset, time.Hour)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		link := fmt.Sprintf("%s/password/reset?token=%s", app.FrontendUrl, url.QueryEscape(token))
+
+		err = app.Mailer.Send(mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your Go Movies password",
+			Body: fmt.Sprintf(
+				"Hi %s,\n\nSomeone asked to reset the password for your account. To choose a new password, open this link within an hour:\n\n%s\n\nIf it wasn't you, you can ignore this email.\n",
+				user.FirstName,
+				link,
+			),
+		})
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "if that address has an account, a reset link has been sent to it",
+	}
+
+	_ = app.writeJson(w, http.StatusAccepted, resp)
+}
+
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := app.readJson(w, r, &requestPayload)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.NotBlank(requestPayload.Token), "token", "must be provided")
+	validatePassword(v, requestPayload.Password)
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
+		return
+	}
+
+	// hash first, so the transaction isn't held open while bcrypt runs
+	var user models.User
+	err = user.SetPassword(requestPayload.Password)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the token is only used up if every session is logged out, too
+	err = app.Db.WithTx(func(repo repository.DatabaseRepo) error {
+		userToken, err := repo.ConsumeUserToken(hashToken(requestPayload.Token), models.ScopePasswordReset)
+		if err != nil {
+			return err
+		}
+
+		err = repo.UpdateUserPassword(userToken.UserId, user.Password)
+		if err != nil {
+			return err
+		}
+
+		// Log out every session, and void any other reset links
+		err = repo.RevokeUserRefreshTokens(userToken.UserId)
+		if err != nil {
+			return err
+		}
+
+		err = repo.DeleteUserTokens(userToken.UserId, models.ScopePasswordReset)
+		if err != nil {
+			return err
+		}
+
+		// Following the emailed link proves the address, too
+		return repo.VerifyUser(userToken.UserId)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJson(w, errors.New("invalid or expired token"))
+		return
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "password updated",
+	}
+
+	_ = app.writeJson(w, http.StatusOK, resp)
+}
+
+// sendVerification emails a user a new link to verify their address with
+func (app *application) sendVerification(r *http.Request, user models.User) {
+	token, err := app.newUserToken(user.Id, models.ScopeVerification, 24*time.Hour)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	link := fmt.Sprintf("%s/verify?token=%s", app.BaseUrl, url.QueryEscape(token))
+
+	err = app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Go Movies account",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nThanks for signing up. Please verify your email address by opening this link within 24 hours:\n\n%s\n",
+			user.FirstName,
+			link,
+		),
+	})
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// sendAccountExists tells the holder of an account that someone tried to
+// sign up with their address again. An account that was never verified
+// gets a new verification link, since the first one may have been lost.
+func (app *application) sendAccountExists(r *http.Request, email string) {
+	user, err := app.Db.GetUserByEmail(email)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	if user.EmailVerifiedAt == nil {
+		app.sendVerification(r, *user)
+		return
+	}
+
+	link := fmt.Sprintf("%s/password/forgot", app.FrontendUrl)
+
+	err = app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "You already have a Go Movies account",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nSomeone tried to sign up with this email address, which already has an account. If it was you, just log in. If you forgot your password, you can reset it here:\n\n%s\n\nIf it wasn't you, you can ignore this email.\n",
+			user.FirstName,
+			link,
+		),
+	})
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// newUserToken stores a single use token for emailing to a user, and
+// returns the plain token
+func (app *application) newUserToken(userId int, scope string, ttl time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = app.Db.InsertUserToken(models.UserToken{
+		UserId:    userId,
+		TokenHash: hashToken(token),
+		Scope:     scope,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// normalizeEmail trims an email and lowercases it, so that an address is
+// stored and looked up the same way however it is typed
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validatePassword checks a new password. bcrypt ignores anything past 72 bytes.
+func validatePassword(v *validator.Validator, password string) {
+	v.Check(len(password) >= 8, "password", "must be at least 8 characters")
+	v.Check(len(password) <= 72, "password", "must be at most 72 bytes")
 }
 
 func (app *application) movieCatalog(w http.ResponseWriter, r *http.Request) {
 	movies, err := app.Db.AllMovies()
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
@@ -160,14 +696,21 @@ func (app *application) GetMovie(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	movieId, err := strconv.Atoi(id)
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
 
 	movie, err := app.Db.OneMovie(movieId)
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	movie.Rating, err = app.Db.MovieRating(movieId)
+	if err != nil {
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
@@ -175,37 +718,24 @@ func (app *application) GetMovie(w http.ResponseWriter, r *http.Request) {
 	_ = app.writeJson(w, http.StatusOK, movie)
 }
 
+// GetMovieForEdit responds with a movie and all genres. The ETag is needed
+// to update or delete the movie.
 func (app *application) GetMovieForEdit(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	movieId, err := strconv.Atoi(id)
 	if err != nil {
-		fmt.Println(err)
-		app.errorJson(w, err)
-		return
-	}
-
-	movie, allGenres, err := app.Db.OneMovieForEdit(movieId)
-	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
 
-	var payload = struct {
-		Movie *models.Movie `json:"movie"`
-		Genres []*models.Genre `json:"genres"`
-	}{
-		movie,
-		allGenres,
-	}
-
-	_ = app.writeJson(w, http.StatusOK, payload)
+	app.writeMovieForEdit(w, r, http.StatusOK, movieId)
 }
 
 func (app *application) AllGenres(w http.ResponseWriter, r *http.Request) {
 	genres, err := app.Db.AllGenres()
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
@@ -218,28 +748,34 @@ func (app *application) InsertMovie(w http.ResponseWriter, r *http.Request) {
 
 	err := app.readJson(w, r, &movie)
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
+	v := validator.New()
+	models.ValidateMovie(v, &movie)
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
+		return
+	}
+
 	movie.CreatedAt = time.Now()
 	movie.UpdatedAt = time.Now()
 
 	// try to get image
-	movie = app.getPoster(movie)
+	movie = app.getPoster(r.Context(), movie)
 
-	// Insert movie
-	newId, err := app.Db.InsertMovie(movie)
-	if err != nil {
-		fmt.Println(err)
-		app.errorJson(w, err)
-		return
-	}
+	// Insert movie and its genres together
+	err = app.Db.WithTx(func(repo repository.DatabaseRepo) error {
+		newId, err := repo.InsertMovie(movie)
+		if err != nil {
+			return err
+		}
 
-	// handle genres
-	err = app.Db.UpdateMovieGenres(newId, movie.GenresArray)
+		return repo.UpdateMovieGenres(newId, movie.GenresArray)
+	})
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
@@ -252,20 +788,24 @@ func (app *application) InsertMovie(w http.ResponseWriter, r *http.Request) {
 	_ = app.writeJson(w, http.StatusOK, resp)
 }
 
+// UpdateMovie changes a movie. If-Match must hold the ETag the movie was
+// read with; if it has changed since, the current movie is returned with 412.
 func (app *application) UpdateMovie(w http.ResponseWriter, r *http.Request) {
 	var payload models.Movie
 
 	err := app.readJson(w, r, &payload)
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
 
-	movie, err := app.Db.OneMovie(payload.Id)
-	if err != nil {
-		fmt.Println(err)
-		app.errorJson(w, err)
+	movie, ok := app.movieFromUrl(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.ifMatch(w, r, movie) {
 		return
 	}
 
@@ -276,16 +816,39 @@ func (app *application) UpdateMovie(w http.ResponseWriter, r *http.Request) {
 	movie.RunTime = payload.RunTime
 	movie.UpdatedAt = time.Now()
 
-	err = app.Db.UpdateMovie(*movie)
-	if err != nil {
-		fmt.Println(err)
-		app.errorJson(w, err)
+	v := validator.New()
+	models.ValidateMovie(v, movie)
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
 		return
 	}
 
-	err = app.Db.UpdateMovieGenres(movie.Id, payload.GenresArray)
+	var version int
+	err = app.Db.WithTx(func(repo repository.DatabaseRepo) error {
+		err := repo.UpdateMovie(*movie)
+		if err != nil {
+			return err
+		}
+
+		err = repo.UpdateMovieGenres(movie.Id, payload.GenresArray)
+		if err != nil {
+			return err
+		}
+
+		updated, err := repo.OneMovie(movie.Id)
+		if err != nil {
+			return err
+		}
+		version = updated.Version
+
+		return nil
+	})
+	if errors.Is(err, models.ErrEditConflict) {
+		app.movieConflict(w, r, movie.Id)
+		return
+	}
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
@@ -294,21 +857,32 @@ func (app *application) UpdateMovie(w http.ResponseWriter, r *http.Request) {
 		Error: false,
 		Message: "movie updated",
 	}
-	app.writeJson(w, http.StatusAccepted, resp)
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieEtag(version))
+
+	app.writeJson(w, http.StatusAccepted, resp, headers)
 }
 
+// DeleteMovie deletes a movie. As with updates, If-Match must hold the ETag
+// of the current version.
 func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	movieId, err := strconv.Atoi(id)
-	if err != nil {
-		fmt.Println(err)
-		app.errorJson(w, err)
+	movie, ok := app.movieFromUrl(w, r)
+	if !ok {
 		return
 	}
 
-	err = app.Db.DeleteMovie(movieId)
+	if !app.ifMatch(w, r, movie) {
+		return
+	}
+
+	err := app.Db.DeleteMovie(movie.Id, movie.Version)
+	if errors.Is(err, models.ErrEditConflict) {
+		app.movieConflict(w, r, movie.Id)
+		return
+	}
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
@@ -320,7 +894,13 @@ func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request) {
 	app.writeJson(w, http.StatusAccepted, resp)
 }
 
-func (app *application) getPoster(movie models.Movie) models.Movie {
+// getPoster fills in the image of a movie from TMDB. ctx is the context of
+// the request the movie is being added in, which the lookup is cancelled
+// with and logged under.
+func (app *application) getPoster(ctx context.Context, movie models.Movie) models.Movie {
+	logger := app.contextLogger(ctx)
+
+
 	type theMovieDb struct {
 		Page int `json:"page"`
 		Results []struct {
@@ -331,36 +911,52 @@ func (app *application) getPoster(movie models.Movie) models.Movie {
 	client := &http.Client{}
 	endpointUrl := fmt.Sprintf("https://api.themoviedb.org/3/search/movie?api_key=%s", app.TmdbApiKey)
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"GET", 
 		endpointUrl + "&query=" + url.QueryEscape(movie.Title),
 		nil,
  	)
 	if err != nil {
-		fmt.Println(err)
+		logger.Warn("poster lookup failed", "title", movie.Title, "error", err)
+		app.Metrics.tmdbFailures.Inc()
 		return movie
 	}
 
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
 
+	start := time.Now()
+	defer func() {
+		app.Metrics.tmdbDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Println(err)
+		logger.Warn("poster lookup failed", "title", movie.Title, "error", err)
+		app.Metrics.tmdbFailures.Inc()
 		return movie
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		logger.Warn("poster lookup failed", "title", movie.Title, "status", resp.StatusCode)
+		app.Metrics.tmdbFailures.Inc()
+		return movie
+	}
+
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Println(err)
+		logger.Warn("poster lookup failed", "title", movie.Title, "error", err)
+		app.Metrics.tmdbFailures.Inc()
 		return movie
 	}
 
 	var responseObject theMovieDb
 	err = json.Unmarshal(bodyBytes, &responseObject)
 	if err != nil {
-		fmt.Println(err)
+		logger.Warn("poster lookup failed", "title", movie.Title, "error", err)
+		app.Metrics.tmdbFailures.Inc()
 		return movie
 	}
 
@@ -375,14 +971,14 @@ func (app *application) AllMoviesByGenre(w http.ResponseWriter, r *http.Request)
 	id := chi.URLParam(r, "id")
 	genreId, err := strconv.Atoi(id)
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
 	
 	movies, err := app.Db.AllMovies(genreId)
 	if err != nil {
-		fmt.Println(err)
+		app.logError(r, err)
 		app.errorJson(w, err)
 		return
 	}
@@ -391,36 +987,158 @@ func (app *application) AllMoviesByGenre(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *application) MoviesGraphQl(w http.ResponseWriter, r *http.Request) {
-	// Populate the graph type with the movies
-	movies, err := app.Db.AllMovies()
+	// Browsers get the GraphiQL IDE in development
+	if r.Method == http.MethodGet && r.URL.Query().Get("query") == "" &&
+		app.Env == config.EnvDevelopment && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := graph.GraphiQL(w, r.URL.Path)
+		if err != nil {
+			app.logError(r, err)
+		}
+		return
+	}
+
+	// Get the request, either from the URL or the body
+	req, err := app.readGraphQlRequest(w, r)
 	if err != nil {
-		fmt.Println(err)
-		app.errorJson(w, err)
+		app.graphQlError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	// Get query from request
-	q, _ := io.ReadAll(r.Body)
-	query := string(q)
-	
-	// Create new var of graph.Graph
-	g := graph.New(movies)
+	// Requests can send the hash of a query instead of the query. One sent
+	// with both is registered after it has run.
+	req, err = app.Graph.Persisted.Resolve(req)
+	if err != nil {
+		// clients retry with the full query when the hash is not found
+		status := http.StatusBadRequest
+		var pqErr *graph.PersistedQueryError
+		if errors.As(err, &pqErr) && pqErr.Code == graph.CodePersistedQueryNotFound {
+			status = http.StatusOK
+		}
 
-	// Set query string on var
-	g.QueryString = query
+		app.graphQlError(w, r, err, status)
+		return
+	}
 
-	// Perform the query
-	response, err := g.Query()
-	if err != nil {
-		fmt.Println(err)
-		app.errorJson(w, err)
+	// GET must not change anything
+	if r.Method == http.MethodGet && req.Operation() != ast.OperationTypeQuery {
+		w.Header().Set("Allow", http.MethodPost)
+		app.graphQlError(w, r, errors.New("only queries can be sent with GET"), http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Send the response
-	j, _ := json.Marshal(response)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	// Mutations need a valid token, queries work without one
+	ctx := r.Context()
+	if r.Header.Get("Authorization") != "" {
+		_, claims, err := app.Auth.getTokenFromHeaderAndVerify(w, r)
+		if err != nil {
+			app.graphQlError(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		userId, _ := strconv.Atoi(claims.Subject)
+		setRequestUser(r, userId)
+		ctx = graph.WithViewer(ctx, &graph.Viewer{UserId: userId, Role: claims.Role})
+	}
+
+	// Perform the query against the shared schema. Errors are part of the
+	// result, next to any data that did resolve.
+	operation := req.Operation()
+	result := app.Graph.Do(ctx, req)
+	for _, e := range result.Errors {
+		app.requestLogger(r).Warn("graphql error", "error", e.Message, "operation", req.OperationName)
+	}
+
+	app.Metrics.graphOperations.WithLabelValues(operation).Inc()
+	if len(result.Errors) > 0 {
+		app.Metrics.graphErrors.WithLabelValues(operation).Inc()
+	}
+
+	// Without data the query did not parse or was over the limits, so it
+	// is not worth keeping
+	if result.Data != nil {
+		app.Graph.Persisted.Register(req)
+	}
+
+	// Send the response. Clients that accept the GraphQL media type learn
+	// from the status whether the request could run at all.
+	status := http.StatusOK
+	if graphQlResponseAccepted(r) && result.Data == nil {
+		status = http.StatusBadRequest
+	}
+
+	app.writeGraphQl(w, r, status, result)
+}
+
+// readGraphQlRequest reads a GraphQL request from the query string of a GET,
+// or the body of a POST. A JSON body holds query, variables and
+// operationName; any other body is the query itself.
+func (app *application) readGraphQlRequest(w http.ResponseWriter, r *http.Request) (graph.Request, error) {
+	if r.Method == http.MethodGet {
+		return graph.RequestFromQuery(r.URL.Query())
+	}
+
+	maxBytes := 1024 * 1024 // one MB
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
-}
\ No newline at end of file
+	var req graph.Request
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return req, fmt.Errorf("body must be a JSON GraphQL request: %v", err)
+		}
+	} else {
+		q, err := io.ReadAll(r.Body)
+		if err != nil {
+			return req, err
+		}
+		req.Query = string(q)
+	}
+
+	if req.Empty() {
+		return req, graph.ErrMissingQuery
+	}
+
+	return req, nil
+}
+
+// graphQlError responds with a request error, in the shape of a GraphQL result
+func (app *application) graphQlError(w http.ResponseWriter, r *http.Request, err error, status int) {
+	formatted := gqlerrors.NewFormattedError(err.Error())
+	if extended, ok := err.(gqlerrors.ExtendedError); ok {
+		formatted.Extensions = extended.Extensions()
+	}
+
+	result := &graphql.Result{
+		Errors: []gqlerrors.FormattedError{formatted},
+	}
+
+	app.writeGraphQl(w, r, status, result)
+}
+
+func (app *application) writeGraphQl(w http.ResponseWriter, r *http.Request, status int, result *graphql.Result) {
+	contentType := "application/json"
+	if graphQlResponseAccepted(r) {
+		contentType = graphQlResponseType
+	}
+
+	// A request that did not run has no data at all, rather than null data
+	var payload any = result
+	if result.Data == nil {
+		payload = struct {
+			Errors []gqlerrors.FormattedError `json:"errors"`
+		}{result.Errors}
+	}
+
+	err := app.writeJson(w, status, payload, http.Header{"Content-Type": {contentType}})
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// graphQlResponseAccepted reports whether the client accepts the GraphQL
+// response media type, which lets errors use HTTP status codes
+func graphQlResponseAccepted(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), graphQlResponseType)
+}
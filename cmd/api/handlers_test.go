@@ -0,0 +1,134 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository/dbrepo"
+	"net/http"
+	"testing"
+)
+
+// refresh exchanges a refresh token, and returns the status and the new
+// refresh token, if there is one
+func refresh(t *testing.T, app *application, token string) (int, string) {
+	t.Helper()
+
+	resp := serve(t, app, http.MethodGet, "/refresh", nil, withRefreshCookie(app, token))
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, ""
+	}
+
+	return resp.StatusCode, refreshCookie(t, app, resp)
+}
+
+func TestRefreshRotates(t *testing.T) {
+	app := newTestApp(t, dbrepo.NewMemoryDbRepo())
+	_, first := login(t, app)
+
+	status, second := refresh(t, app, first)
+	if status != http.StatusOK {
+		t.Fatalf("refresh: status %d, want %d", status, http.StatusOK)
+	}
+	if second == first {
+		t.Fatal("refresh returned the same token")
+	}
+
+	status, third := refresh(t, app, second)
+	if status != http.StatusOK {
+		t.Fatalf("refresh with the rotated token: status %d, want %d", status, http.StatusOK)
+	}
+	if third == second {
+		t.Fatal("refresh returned the same token")
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	app := newTestApp(t, dbrepo.NewMemoryDbRepo())
+	_, first := login(t, app)
+	_, other := login(t, app)
+
+	_, second := refresh(t, app, first)
+
+	// replaying the rotated token logs out everything issued since
+	status, _ := refresh(t, app, first)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("reusing a rotated token: status %d, want %d", status, http.StatusUnauthorized)
+	}
+	status, _ = refresh(t, app, second)
+	if status != http.StatusUnauthorized {
+		t.Errorf("refresh with the token issued after the reused one: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	// other logins are other families, and keep working
+	status, _ = refresh(t, app, other)
+	if status != http.StatusOK {
+		t.Errorf("refresh of another login: status %d, want %d", status, http.StatusOK)
+	}
+}
+
+// racingRepo runs race once, between looking up a refresh token and
+// revoking it, as a concurrent refresh with the same token could
+type racingRepo struct {
+	*dbrepo.MemoryDbRepo
+	race func()
+}
+
+func (r *racingRepo) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	token, err := r.MemoryDbRepo.GetRefreshTokenByHash(hash)
+
+	if race := r.race; race != nil {
+		r.race = nil
+		race()
+	}
+
+	return token, err
+}
+
+func TestRefreshRaceLoserRevokesFamily(t *testing.T) {
+	repo := &racingRepo{MemoryDbRepo: dbrepo.NewMemoryDbRepo()}
+	app := newTestApp(t, repo.MemoryDbRepo)
+	app.Db = repo
+	_, token := login(t, app)
+
+	var winner string
+	repo.race = func() {
+		var status int
+		status, winner = refresh(t, app, token)
+		if status != http.StatusOK {
+			t.Fatalf("the first refresh: status %d, want %d", status, http.StatusOK)
+		}
+	}
+
+	// the token was valid when looked up, but the other refresh used it
+	// first, so this one counts as reuse
+	status, _ := refresh(t, app, token)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("the refresh that lost the race: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	status, _ = refresh(t, app, winner)
+	if status != http.StatusUnauthorized {
+		t.Errorf("refresh with the token the winner got: status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestLogoutRevokesServerSide(t *testing.T) {
+	app := newTestApp(t, dbrepo.NewMemoryDbRepo())
+	_, first := login(t, app)
+	_, second := refresh(t, app, first)
+
+	resp := serve(t, app, http.MethodGet, "/logout", nil, withRefreshCookie(app, second))
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("logout: status %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	for _, c := range resp.Cookies() {
+		if c.Name == app.Auth.CookieName && c.MaxAge >= 0 {
+			t.Error("logout did not expire the refresh cookie")
+		}
+	}
+
+	// a copy of the cookie kept from before logging out is no use
+	status, _ := refresh(t, app, second)
+	if status != http.StatusUnauthorized {
+		t.Errorf("refresh after logout: status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"backend/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// The lists are addressed by name, e.g. /me/watchlist/12
+const listPattern = "{list:" + models.ListWatchlist + "|" + models.ListFavorites + "}"
+
+// MovieList lists the movies on one of the signed in user's lists, in
+// list order
+func (app *application) MovieList(w http.ResponseWriter, r *http.Request) {
+	list := chi.URLParam(r, "list")
+
+	entries, err := app.Db.ListEntries(requestUserId(r), list)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	// an empty list is still a list, not null
+	if entries == nil {
+		entries = []*models.ListEntry{}
+	}
+
+	var payload = struct {
+		Movies []*models.ListEntry `json:"movies"`
+		Total  int                 `json:"total"`
+	}{
+		Movies: entries,
+		Total:  len(entries),
+	}
+
+	_ = app.writeJson(w, http.StatusOK, payload)
+}
+
+// AddToList puts a movie at the end of a list. Adding a movie already on
+// the list changes nothing.
+func (app *application) AddToList(w http.ResponseWriter, r *http.Request) {
+	list := chi.URLParam(r, "list")
+	movieId, err := strconv.Atoi(chi.URLParam(r, "movieId"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	_, err = app.Db.OneMovie(movieId)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJson(w, errors.New("movie not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	added, err := app.Db.AddListEntry(requestUserId(r), list, movieId)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	resp := JsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("movie already on %s", list),
+	}
+	if added {
+		status = http.StatusCreated
+		resp.Message = fmt.Sprintf("movie added to %s", list)
+	}
+
+	_ = app.writeJson(w, status, resp)
+}
+
+func (app *application) RemoveFromList(w http.ResponseWriter, r *http.Request) {
+	list := chi.URLParam(r, "list")
+	movieId, err := strconv.Atoi(chi.URLParam(r, "movieId"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	removed, err := app.Db.RemoveListEntry(requestUserId(r), list, movieId)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+	if !removed {
+		app.errorJson(w, fmt.Errorf("movie not on %s", list), http.StatusNotFound)
+		return
+	}
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("movie removed from %s", list),
+	}
+	_ = app.writeJson(w, http.StatusAccepted, resp)
+}
+
+// ReorderList puts a list in a new order. The body lists every movie on
+// the list once:
+//
+//	{"movie_ids": [3, 1, 2]}
+func (app *application) ReorderList(w http.ResponseWriter, r *http.Request) {
+	list := chi.URLParam(r, "list")
+
+	var payload struct {
+		MovieIds []int `json:"movie_ids"`
+	}
+	err := app.readJson(w, r, &payload)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	err = app.Db.ReorderList(requestUserId(r), list, payload.MovieIds)
+	if errors.Is(err, models.ErrInvalidOrder) {
+		app.failedValidation(w, map[string]string{"movie_ids": err.Error()})
+		return
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	app.MovieList(w, r)
+}
+
+// MarkWatched records that a movie on a list was watched, now or at the
+// given time, or clears it:
+//
+//	{"watched": true, "watched_at": "2022-09-23T20:00:00Z"}
+//	{"watched": false}
+func (app *application) MarkWatched(w http.ResponseWriter, r *http.Request) {
+	list := chi.URLParam(r, "list")
+	movieId, err := strconv.Atoi(chi.URLParam(r, "movieId"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	var payload struct {
+		Watched   bool       `json:"watched"`
+		WatchedAt *time.Time `json:"watched_at"`
+	}
+	err = app.readJson(w, r, &payload)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	var watchedAt *time.Time
+	if payload.Watched {
+		watchedAt = payload.WatchedAt
+		if watchedAt == nil {
+			now := time.Now()
+			watchedAt = &now
+		}
+
+		if watchedAt.After(time.Now()) {
+			app.failedValidation(w, map[string]string{"watched_at": "must not be in the future"})
+			return
+		}
+	}
+
+	found, err := app.Db.SetListEntryWatched(requestUserId(r), list, movieId, watchedAt)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+	if !found {
+		app.errorJson(w, fmt.Errorf("movie not on %s", list), http.StatusNotFound)
+		return
+	}
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "movie marked unwatched",
+	}
+	if watchedAt != nil {
+		resp.Message = "movie marked watched"
+	}
+	_ = app.writeJson(w, http.StatusOK, resp)
+}
@@ -1,68 +1,190 @@
 package main
 
 import (
+	"backend/internal/config"
+	"backend/internal/graph"
+	"backend/internal/jsonlog"
+	"backend/internal/keyring"
+	"backend/internal/mailer"
 	"backend/internal/repository"
 	"backend/internal/repository/dbrepo"
+	"context"
+	"errors"
 	"flag"
-	"fmt"
 	"log"
-	"net/http"
-	"time"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 )
 
-const port = 8080
-
 type application struct {
-	Domain       string
-	Dsn          string
-	Db           repository.DatabaseRepo
-	Auth         auth
-	JwtSecret    string
-	JwtIssuer    string
-	JwtAudience  string
-	CookieDomain string
-	TmdbApiKey   string
+	config.Config
+
+	Logger  *jsonlog.Logger
+	Metrics *metrics
+	Db      repository.DatabaseRepo
+	Auth    auth
+	Mailer  mailer.Mailer
+	Graph   *graph.Graph
+
+	// wg tracks background tasks, such as sending mail
+	wg sync.WaitGroup
 }
 
 func main() {
-	// set application config
+	// read the config from flags, the environment and the config file
+	cfg, err := config.Load(os.Args[0], os.Args[1:], os.Environ())
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	var app application
+	app.Config = *cfg
 
-	// read flags from command line
-	flag.StringVar(&app.Dsn, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=movies sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection string")
-	flag.StringVar(&app.JwtSecret, "jwt-secret", "development-secret", "signing secret")
-	flag.StringVar(&app.JwtIssuer, "jwt-issuer", "example.com", "signing issuer")
-	flag.StringVar(&app.JwtAudience, "jwt-audience", "example.com", "signing audience")
-	flag.StringVar(&app.Domain, "domain", "example.com", "domain")
-	flag.StringVar(&app.CookieDomain, "cookie-domain", "localhost", "cookie domain")
-	flag.StringVar(&app.TmdbApiKey, "tmdb-api-key", "", "api key")
+	// log JSON to stdout, including what libraries log through the standard
+	// logger
+	level, _ := jsonlog.ParseLevel(app.LogLevel)
+	app.Logger = jsonlog.New(os.Stdout, level)
+	log.SetFlags(0)
+	log.SetOutput(app.Logger.StdLogger(jsonlog.LevelInfo).Writer())
+
+	// the migrate subcommand changes the schema instead of serving requests
+	if args := cfg.Args(); len(args) > 0 && args[0] == "migrate" {
+		err := app.runMigrate(args[1:])
+		if err != nil {
+			app.fatal("migration failed", err)
+		}
+		return
+	}
+
+	// the seed subcommand loads sample data into a development database
+	if args := cfg.Args(); len(args) > 0 && args[0] == "seed" {
+		err := app.runSeed(args[1:])
+		if err != nil {
+			app.fatal("seeding failed", err)
+		}
+		return
+	}
+
+	// the user subcommand manages accounts, such as granting roles
+	if args := cfg.Args(); len(args) > 0 && args[0] == "user" {
+		err := app.runUser(args[1:])
+		if err != nil {
+			app.fatal("user command failed", err)
+		}
+		return
+	}
 
-	flag.Parse()
+	app.Logger.Info("effective config", "settings", cfg.Settings())
 
-	// connect to database
-	conn, err := app.connectToDb()
+	app.Metrics = newMetrics()
+
+	// set up the mailer
+	switch app.MailerType {
+	case "smtp":
+		app.Mailer = &mailer.SMTP{
+			Host:     app.SmtpHost,
+			Port:     app.SmtpPort,
+			Username: app.SmtpUsername,
+			Password: app.SmtpPassword,
+			From:     app.MailFrom,
+		}
+	case "log":
+		app.Mailer = &mailer.Log{Dir: app.MailDir, From: app.MailFrom}
+	}
+
+	// set up the repository
+	switch app.Repo {
+	case "postgres":
+		conn, err := app.connectToDb()
+		if err != nil {
+			app.fatal("cannot connect to Postgres", err)
+		}
+		app.Db = &dbrepo.PostgresDbRepo{Db: conn}
+		app.Metrics.registerDb(conn)
+	case "memory":
+		repo := dbrepo.NewMemoryDbRepo()
+		if app.Fixtures != "" {
+			err := repo.Seed(app.Fixtures)
+			if err != nil {
+				app.fatal("cannot seed the in-memory repository", err)
+			}
+		}
+		app.Db = repo
+		app.Logger.Info("using in-memory repository")
+	}
+
+	// time every repository method, including those GraphQL resolvers call
+	app.Db = &dbrepo.InstrumentedDbRepo{DatabaseRepo: app.Db, Observe: app.Metrics.observeQuery}
+
+	// build the GraphQL schema
+	app.Graph, err = graph.New(app.Db)
 	if err != nil {
-		log.Fatal(err)
+		app.fatal("cannot build the GraphQL schema", err)
+	}
+	app.Graph.Limits = app.GraphLimits
+
+	if app.GraphManifest != "" {
+		err = app.Graph.Persisted.LoadManifest(app.GraphManifest)
+		if err != nil {
+			app.fatal("cannot load the persisted query manifest", err)
+		}
+	}
+	app.Graph.Persisted.AllowlistOnly = app.GraphAllowlist
+	app.Graph.FindPoster = app.getPoster
+
+	// stop on SIGINT (Ctrl-C) or SIGTERM (as sent by docker stop and systemd)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// set up signing keys
+	var keys *keyring.Keyring
+	if app.JwtAlgorithm == keyring.HS256 {
+		keys = keyring.NewHMAC(app.JwtSecret)
+	} else {
+		keys, err = keyring.New(app.JwtAlgorithm, app.JwtKeyDir, app.JwtRotation, app.JwtRetention)
+		if err != nil {
+			app.fatal("cannot set up signing keys", err)
+		}
+		go keys.AutoRotate(ctx)
 	}
-	app.Db = &dbrepo.PostgresDbRepo{Db: conn}
-	defer app.Db.Connection().Close()
 
 	app.Auth = auth{
 		Issuer: app.JwtIssuer,
 		Audience: app.JwtAudience,
-		Secret: app.JwtSecret,
-		TokenExpiry: time.Minute * 15,
-		RefreshExpiry: time.Hour * 24,
+		Keys: keys,
+		TokenExpiry: app.TokenExpiry,
+		RefreshExpiry: app.RefreshExpiry,
 		CookiePath: "/",
 		CookieName: "_unsecure_Host-refresh_token",
 		CookieDomain: app.CookieDomain,
 	}
 
 	// start web server
-	log.Println("Starting application on port", port)
+	err = app.serve(ctx)
+	stop()
+
+	// close the pool only once no request or background task can use it
+	if conn := app.Db.Connection(); conn != nil {
+		conn.Close()
+	}
 
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
 	if err != nil {
-		log.Fatal(err)
+		app.fatal("server failed", err)
 	}
 }
+
+// fatal logs an error that keeps the API from running, and exits
+func (app *application) fatal(msg string, err error) {
+	app.Logger.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"backend/internal/config"
+	"backend/internal/jsonlog"
+	"backend/internal/keyring"
+	"backend/internal/mailer"
+	"backend/internal/repository/dbrepo"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestApp is the API as main sets it up with -repo=memory, on the sample
+// data. repo is what it keeps its data in, so tests can wrap it.
+func newTestApp(t *testing.T, repo *dbrepo.MemoryDbRepo) *application {
+	t.Helper()
+
+	cfg, err := config.Load("api", []string{"-repo=memory", "-fixtures=../../sql/seed.json"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var app application
+	app.Config = *cfg
+	app.Logger = jsonlog.New(io.Discard, jsonlog.LevelError)
+	app.Metrics = newMetrics()
+	app.Mailer = &mailer.Log{Dir: t.TempDir(), From: app.MailFrom}
+
+	err = repo.Seed(app.Fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Db = repo
+
+	// the sample admin's hash takes over a second to check, so every login
+	// would; the tests log in with a cheap one instead
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := repo.GetUserByEmail("admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.UpdateUserPassword(admin.Id, string(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Auth = auth{
+		Issuer:        app.JwtIssuer,
+		Audience:      app.JwtAudience,
+		Keys:          keyring.NewHMAC(app.JwtSecret),
+		TokenExpiry:   app.TokenExpiry,
+		RefreshExpiry: app.RefreshExpiry,
+		CookiePath:    "/",
+		CookieName:    "refresh_token",
+		CookieDomain:  app.CookieDomain,
+	}
+
+	return &app
+}
+
+// serve sends a request to the API and returns the response. body, if not
+// nil, is sent as JSON.
+func serve(t *testing.T, app *application, method, path string, body any, headers http.Header) *http.Response {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	for name, values := range headers {
+		r.Header[name] = values
+	}
+
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, r)
+
+	return w.Result()
+}
+
+// readJson decodes the body of a response
+func readJson(t *testing.T, resp *http.Response, data any) {
+	t.Helper()
+
+	err := json.NewDecoder(resp.Body).Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// login signs in as the sample admin, and returns the access token and the
+// refresh token
+func login(t *testing.T, app *application) (string, string) {
+	t.Helper()
+
+	resp := serve(t, app, http.MethodPost, "/authenticate", map[string]string{
+		"email":    "admin@example.com",
+		"password": "secret",
+	}, nil)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("logging in: status %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+
+	var tokens tokenPairs
+	readJson(t, resp, &tokens)
+
+	return tokens.Token, refreshCookie(t, app, resp)
+}
+
+// refreshCookie returns the refresh token a response sets
+func refreshCookie(t *testing.T, app *application, resp *http.Response) string {
+	t.Helper()
+
+	for _, c := range resp.Cookies() {
+		if c.Name == app.Auth.CookieName {
+			return c.Value
+		}
+	}
+
+	t.Fatal("no refresh cookie set")
+	return ""
+}
+
+// withRefreshCookie is the header that sends a refresh token
+func withRefreshCookie(app *application, token string) http.Header {
+	c := &http.Cookie{Name: app.Auth.CookieName, Value: token}
+	return http.Header{"Cookie": {c.String()}}
+}
+
+// bearer is the header that sends an access token
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
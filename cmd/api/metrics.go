@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are what the API exports to Prometheus on /metrics. They live in
+// their own registry rather than the global one, so that only what is
+// registered here is exported.
+type metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	dbQueryDuration *prometheus.HistogramVec
+
+	graphOperations *prometheus.CounterVec
+	graphErrors     *prometheus.CounterVec
+
+	tmdbDuration prometheus.Histogram
+	tmdbFailures prometheus.Counter
+
+	logins *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+
+		// requests are labelled by route pattern, e.g. /movies/{id}, so
+		// that the number of series does not grow with the ids requested
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests served, by method, route pattern and status.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests, by method, route pattern and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+
+		dbQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Time taken by repository methods, by method.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 3},
+		}, []string{"method"}),
+
+		graphOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "graphql_operations_total",
+			Help: "GraphQL operations run, by type.",
+		}, []string{"type"}),
+		graphErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "graphql_errors_total",
+			Help: "GraphQL operations whose result has errors, by type.",
+		}, []string{"type"}),
+
+		tmdbDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "tmdb_request_duration_seconds",
+			Help:    "Time taken to look up posters on TMDB.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		tmdbFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "tmdb_failures_total",
+			Help: "Poster lookups on TMDB that failed.",
+		}),
+
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "login_attempts_total",
+			Help: "Login attempts, by result (success or failure).",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.dbQueryDuration,
+		m.graphOperations,
+		m.graphErrors,
+		m.tmdbDuration,
+		m.tmdbFailures,
+		m.logins,
+	)
+
+	// start every login series at zero, so that rates work from the start
+	m.logins.WithLabelValues("success")
+	m.logins.WithLabelValues("failure")
+
+	return m
+}
+
+// registerDb exports the connection pool stats of db
+func (m *metrics) registerDb(db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, "movies"))
+}
+
+// handler serves the metrics in the Prometheus text format
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// observeQuery records the duration of a repository method
+func (m *metrics) observeQuery(method string, took time.Duration) {
+	m.dbQueryDuration.WithLabelValues(method).Observe(took.Seconds())
+}
+
+// login records the result of a login attempt
+func (m *metrics) login(ok bool) {
+	if ok {
+		m.logins.WithLabelValues("success").Inc()
+	} else {
+		m.logins.WithLabelValues("failure").Inc()
+	}
+}
+
+// knownMethods are the request methods that get a series of their own
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+// methodLabel is the method label of a request. Clients can send any method,
+// so the rest share "other", or they could add series without end.
+func methodLabel(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+
+	return "other"
+}
+
+// measureRequests counts every request and the time it took, by the route
+// pattern it matched. The pattern is only known once the router has run, so
+// it is read after the request is served.
+func (app *application) measureRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		// requests that match no route, such as CORS preflights and 404s,
+		// share one series
+		route := "none"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		labels := prometheus.Labels{
+			"method": methodLabel(r.Method),
+			"route":  route,
+			"status": strconv.Itoa(status),
+		}
+		app.Metrics.httpRequests.With(labels).Inc()
+		app.Metrics.httpDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
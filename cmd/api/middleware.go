@@ -1,33 +1,207 @@
 package main
 
-import "net/http"
+import (
+	"backend/internal/models"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
 
 func (app *application) enableCors(h http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
+			// only allowed origins are echoed back, as credentials rule out *
+			w.Header().Add("Vary", "Origin")
+			if origin := r.Header.Get("Origin"); app.corsAllowed(origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
 			if r.Method == "OPTIONS" {
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
 				w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization")
+				w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization, If-Match")
 
 				return
 			}
 
+			// let the frontend read the version of a movie it edits
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
 			h.ServeHTTP(w, r)
 		},
 	)
 }
 
+func (app *application) corsAllowed(origin string) bool {
+	for _, allowed := range app.CorsOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+const requestInfoContextKey contextKey = "request"
+
+// requestInfo is what the access log records about a request besides the
+// request itself. Later middleware fills in the user.
+type requestInfo struct {
+	Id     string
+	UserId int
+}
+
+// maxRequestIdLength bounds the request IDs accepted from clients
+const maxRequestIdLength = 128
+
+// requestId gives every request an ID, which it returns in the X-Request-ID
+// header. An ID sent by the client or a proxy in the same header is kept,
+// so that a request can be followed across services.
+func (app *application) requestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestInfoContextKey, &requestInfo{Id: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+
+	for _, c := range id {
+		ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == ':'
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestInfoFrom returns the info of a request that passed requestId, or nil
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
+
+// logRequests writes an access log entry for every request once it is
+// served. It must run after requestId.
+func (app *application) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		// a handler that writes nothing sends a 200
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		args := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"latency", time.Since(start),
+			"bytes", ww.BytesWritten(),
+		}
+
+		info := requestInfoFrom(r)
+		if info != nil {
+			args = append(args, "request_id", info.Id)
+			if info.UserId != 0 {
+				args = append(args, "user_id", info.UserId)
+			}
+		}
+
+		if status >= http.StatusInternalServerError {
+			app.Logger.Error("request", args...)
+		} else {
+			app.Logger.Info("request", args...)
+		}
+	})
+}
+
+// setRequestUser records the user signed in to a request for the access log
+func setRequestUser(r *http.Request, userId int) {
+	if info := requestInfoFrom(r); info != nil {
+		info.UserId = userId
+	}
+}
+
 func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.Auth.getTokenFromHeaderAndVerify(w, r)
+		_, claims, err := app.Auth.getTokenFromHeaderAndVerify(w, r)
 
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// make the claims available to later middleware and handlers
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+
+		userId, _ := strconv.Atoi(claims.Subject)
+		setRequestUser(r, userId)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
+}
+
+// requestUserId returns the id of the user signed in to a request that
+// passed authRequired, or 0
+func requestUserId(r *http.Request) int {
+	claims, ok := r.Context().Value(claimsContextKey).(*claims)
+	if !ok {
+		return 0
+	}
+
+	userId, _ := strconv.Atoi(claims.Subject)
+	return userId
+}
+
+// requireRole only lets through users with at least the given role. It must
+// run after authRequired; a valid token without the role gets a 403.
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(claimsContextKey).(*claims)
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if !models.RoleAllows(claims.Role, role) {
+				app.errorJson(w, errors.New("forbidden"), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
\ No newline at end of file
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"backend/internal/migrate"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const migrateUsage = "usage: api [flags] migrate up|down|status|to N|baseline N"
+
+// runMigrate runs the migrate subcommand against the database of -dsn:
+//
+//	migrate up          apply all pending migrations
+//	migrate down        roll back the last migration
+//	migrate status      list the migrations and whether they are applied
+//	migrate to N        migrate up or down to version N, 0 to roll back all
+//	migrate baseline N  record versions up to N as applied without running
+//	                    them, for a database created from the old pg_dump
+//	                    (version 1)
+func (app *application) runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	conn, err := app.connectToDb()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m, err := migrate.New(conn)
+	if err != nil {
+		return err
+	}
+	m.Log = func(format string, args ...any) {
+		app.Logger.Info(fmt.Sprintf(format, args...))
+	}
+
+	ctx := context.Background()
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		return m.Up(ctx)
+
+	case args[0] == "down" && len(args) == 1:
+		return m.Down(ctx)
+
+	case args[0] == "to" && len(args) == 2:
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return m.To(ctx, version)
+
+	case args[0] == "baseline" && len(args) == 2:
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return m.Baseline(ctx, version)
+
+	case args[0] == "status" && len(args) == 1:
+		list, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range list {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return tw.Flush()
+	}
+
+	return errors.New(migrateUsage)
+}
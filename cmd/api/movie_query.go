@@ -0,0 +1,179 @@
+package main
+
+import (
+	"backend/internal/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMovieLimit = 20
+	maxMovieLimit     = 100
+)
+
+// readMovieQuery builds a movie listing query from the URL. Pagination is by
+// page number when "page" is given, otherwise by keyset "cursor".
+//
+//	/movies?sort=release_date&order=desc&limit=10&page=2
+//	/movies?rating=PG-13,R&year_from=1980&year_to=1989&runtime_min=90
+//	/movies?genre=5,11&genre_match=all
+func readMovieQuery(r *http.Request) (models.MovieQuery, error) {
+	v := r.URL.Query()
+
+	q := models.MovieQuery{
+		Sort:  models.SortTitle,
+		Limit: defaultMovieLimit,
+	}
+
+	if sort := v.Get("sort"); sort != "" {
+		switch sort {
+		case models.SortTitle, models.SortReleaseDate, models.SortRunTime, models.SortCreatedAt:
+			q.Sort = sort
+		default:
+			return q, fmt.Errorf("invalid sort %q", sort)
+		}
+	}
+
+	switch v.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, errors.New("order must be asc or desc")
+	}
+
+	var err error
+
+	if q.Limit, err = intParam(v, "limit", defaultMovieLimit); err != nil {
+		return q, err
+	}
+	if q.Limit < 1 || q.Limit > maxMovieLimit {
+		return q, fmt.Errorf("limit must be between 1 and %d", maxMovieLimit)
+	}
+
+	if v.Has("page") && v.Has("cursor") {
+		return q, errors.New("page and cursor cannot be used together")
+	}
+
+	if q.Page, err = intParam(v, "page", 0); err != nil {
+		return q, err
+	}
+	if v.Has("page") && q.Page < 1 {
+		return q, errors.New("page must be 1 or more")
+	}
+
+	if token := v.Get("cursor"); token != "" {
+		q.Cursor, err = models.DecodeMovieCursor(token)
+		if err != nil {
+			return q, err
+		}
+		if _, err = q.CursorValue(); err != nil {
+			return q, err
+		}
+	}
+
+	q.Ratings = listParam(v, "rating")
+
+	if q.YearFrom, err = intParam(v, "year_from", 0); err != nil {
+		return q, err
+	}
+	if q.YearTo, err = intParam(v, "year_to", 0); err != nil {
+		return q, err
+	}
+	if q.RunTimeMin, err = intParam(v, "runtime_min", 0); err != nil {
+		return q, err
+	}
+	if q.RunTimeMax, err = intParam(v, "runtime_max", 0); err != nil {
+		return q, err
+	}
+
+	seen := make(map[int]bool)
+	for _, value := range listParam(v, "genre") {
+		genreId, err := strconv.Atoi(value)
+		if err != nil {
+			return q, fmt.Errorf("invalid genre %q", value)
+		}
+
+		// duplicates would throw off matching all genres
+		if !seen[genreId] {
+			seen[genreId] = true
+			q.Genres = append(q.Genres, genreId)
+		}
+	}
+
+	switch v.Get("genre_match") {
+	case "", "any":
+	case "all":
+		q.MatchAllGenres = true
+	default:
+		return q, errors.New("genre_match must be any or all")
+	}
+
+	return q, nil
+}
+
+// movieListLink returns the URL of the next or previous page of a listing,
+// keeping the filters of the current request
+func movieListLink(r *http.Request, q models.MovieQuery, page *models.MoviePage, prev bool) string {
+	v := r.URL.Query()
+
+	if v.Has("page") {
+		if prev {
+			v.Set("page", strconv.Itoa(q.Page-1))
+		} else {
+			v.Set("page", strconv.Itoa(q.Page+1))
+		}
+	} else if len(page.Movies) > 0 {
+		edge := page.Movies[len(page.Movies)-1]
+		if prev {
+			edge = page.Movies[0]
+		}
+
+		cursor := models.MovieCursor{
+			Value:  q.SortValue(edge),
+			Id:     edge.Id,
+			Before: prev,
+		}
+		v.Set("cursor", cursor.Encode())
+	} else {
+		// nothing to anchor a cursor on, so go back to the start
+		v.Del("cursor")
+	}
+
+	u := url.URL{Path: r.URL.Path, RawQuery: v.Encode()}
+
+	return u.String()
+}
+
+func intParam(v url.Values, key string, fallback int) (int, error) {
+	s := v.Get(key)
+	if s == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number", key)
+	}
+
+	return n, nil
+}
+
+// listParam reads a parameter given either repeatedly or comma separated
+func listParam(v url.Values, key string) []string {
+	var list []string
+	for _, value := range v[key] {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
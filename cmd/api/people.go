@@ -0,0 +1,302 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/validator"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GetPerson returns a person with their filmography
+func (app *application) GetPerson(w http.ResponseWriter, r *http.Request) {
+	person, ok := app.personFromUrl(w, r)
+	if !ok {
+		return
+	}
+
+	_ = app.writeJson(w, http.StatusOK, person)
+}
+
+func (app *application) AllPeople(w http.ResponseWriter, r *http.Request) {
+	people, err := app.Db.AllPeople()
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	// no people is still a list, not null
+	if people == nil {
+		people = []*models.Person{}
+	}
+
+	_ = app.writeJson(w, http.StatusOK, people)
+}
+
+func (app *application) InsertPerson(w http.ResponseWriter, r *http.Request) {
+	var person models.Person
+
+	err := app.readJson(w, r, &person)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	v := validator.New()
+	models.ValidatePerson(v, &person)
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
+		return
+	}
+
+	person.CreatedAt = time.Now()
+	person.UpdatedAt = time.Now()
+
+	newId, err := app.Db.InsertPerson(person)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	saved, err := app.Db.OnePerson(newId)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	_ = app.writeJson(w, http.StatusCreated, saved)
+}
+
+func (app *application) UpdatePerson(w http.ResponseWriter, r *http.Request) {
+	person, ok := app.personFromUrl(w, r)
+	if !ok {
+		return
+	}
+
+	var payload models.Person
+	err := app.readJson(w, r, &payload)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	person.Name = payload.Name
+	person.Birthday = payload.Birthday
+	person.Biography = payload.Biography
+	person.Image = payload.Image
+	person.UpdatedAt = time.Now()
+
+	v := validator.New()
+	models.ValidatePerson(v, person)
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
+		return
+	}
+
+	err = app.Db.UpdatePerson(*person)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "person updated",
+	}
+	_ = app.writeJson(w, http.StatusAccepted, resp)
+}
+
+// DeletePerson removes a person along with their credits
+func (app *application) DeletePerson(w http.ResponseWriter, r *http.Request) {
+	personId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	err = app.Db.DeletePerson(personId)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "person deleted",
+	}
+	_ = app.writeJson(w, http.StatusAccepted, resp)
+}
+
+// InsertCredit credits a person for a movie:
+//
+//	{"movie_id": 1, "type": "cast", "character": "Connor MacLeod", "billing": 1}
+func (app *application) InsertCredit(w http.ResponseWriter, r *http.Request) {
+	person, ok := app.personFromUrl(w, r)
+	if !ok {
+		return
+	}
+
+	credit, ok := app.readCredit(w, r)
+	if !ok {
+		return
+	}
+	credit.PersonId = person.Id
+
+	newId, err := app.Db.InsertCredit(*credit)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+	credit.Id = newId
+
+	_ = app.writeJson(w, http.StatusCreated, credit)
+}
+
+func (app *application) UpdateCredit(w http.ResponseWriter, r *http.Request) {
+	personId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	creditId, err := strconv.Atoi(chi.URLParam(r, "creditId"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	credit, ok := app.readCredit(w, r)
+	if !ok {
+		return
+	}
+	credit.Id = creditId
+	credit.PersonId = personId
+
+	found, err := app.Db.UpdateCredit(*credit)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+	if !found {
+		app.errorJson(w, errors.New("credit not found"), http.StatusNotFound)
+		return
+	}
+
+	_ = app.writeJson(w, http.StatusOK, credit)
+}
+
+func (app *application) DeleteCredit(w http.ResponseWriter, r *http.Request) {
+	personId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	creditId, err := strconv.Atoi(chi.URLParam(r, "creditId"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	found, err := app.Db.DeleteCredit(personId, creditId)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+	if !found {
+		app.errorJson(w, errors.New("credit not found"), http.StatusNotFound)
+		return
+	}
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "credit deleted",
+	}
+	_ = app.writeJson(w, http.StatusAccepted, resp)
+}
+
+// personFromUrl loads the person in the URL, or responds with an error and
+// returns false
+func (app *application) personFromUrl(w http.ResponseWriter, r *http.Request) (*models.Person, bool) {
+	personId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return nil, false
+	}
+
+	person, err := app.Db.OnePerson(personId)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJson(w, errors.New("person not found"), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return nil, false
+	}
+
+	return person, true
+}
+
+// readCredit reads and validates a credit, checking that its movie exists
+func (app *application) readCredit(w http.ResponseWriter, r *http.Request) (*models.Credit, bool) {
+	var payload struct {
+		MovieId   int    `json:"movie_id"`
+		Type      string `json:"type"`
+		Character string `json:"character"`
+		Billing   int    `json:"billing"`
+	}
+
+	err := app.readJson(w, r, &payload)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return nil, false
+	}
+
+	credit := &models.Credit{
+		MovieId:   payload.MovieId,
+		Type:      payload.Type,
+		Character: payload.Character,
+		Billing:   payload.Billing,
+	}
+
+	v := validator.New()
+	models.ValidateCredit(v, credit)
+	if v.Valid() {
+		_, err = app.Db.OneMovie(credit.MovieId)
+		if errors.Is(err, sql.ErrNoRows) {
+			v.AddError("movie_id", "movie does not exist")
+		} else if err != nil {
+			app.logError(r, err)
+			app.errorJson(w, err)
+			return nil, false
+		}
+	}
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
+		return nil, false
+	}
+
+	return credit, true
+}
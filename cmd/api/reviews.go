@@ -0,0 +1,234 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/validator"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultReviewLimit = 20
+	maxReviewLimit     = 100
+)
+
+// reviewInput is what a user sends to write or edit a review
+type reviewInput struct {
+	Rating int    `json:"rating"`
+	Body   string `json:"body"`
+}
+
+// MovieReviews lists the reviews of a movie, newest first
+//
+//	/movies/1/reviews?page=2&limit=10
+func (app *application) MovieReviews(w http.ResponseWriter, r *http.Request) {
+	movieId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	v := r.URL.Query()
+	page, err := intParam(v, "page", 1)
+	if err == nil && page < 1 {
+		err = errors.New("page must be 1 or more")
+	}
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	limit, err := intParam(v, "limit", defaultReviewLimit)
+	if err == nil && (limit < 1 || limit > maxReviewLimit) {
+		err = fmt.Errorf("limit must be between 1 and %d", maxReviewLimit)
+	}
+	if err != nil {
+		app.errorJson(w, err)
+		return
+	}
+
+	reviews, total, err := app.Db.ReviewsForMovie(movieId, page, limit)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	var payload = struct {
+		Reviews []*models.Review `json:"reviews"`
+		Total   int              `json:"total"`
+		Limit   int              `json:"limit"`
+		Page    int              `json:"page"`
+	}{
+		Reviews: reviews,
+		Total:   total,
+		Limit:   limit,
+		Page:    page,
+	}
+
+	// an empty page is still a list, not null
+	if payload.Reviews == nil {
+		payload.Reviews = []*models.Review{}
+	}
+
+	_ = app.writeJson(w, http.StatusOK, payload)
+}
+
+// InsertReview posts the signed in user's review of a movie. Each user
+// reviews a movie once, and edits that review afterwards.
+func (app *application) InsertReview(w http.ResponseWriter, r *http.Request) {
+	movieId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	var input reviewInput
+	err = app.readJson(w, r, &input)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	review := models.Review{
+		MovieId:   movieId,
+		UserId:    requestUserId(r),
+		Rating:    input.Rating,
+		Body:      input.Body,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	v := validator.New()
+	models.ValidateReview(v, &review)
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
+		return
+	}
+
+	_, err = app.Db.OneMovie(movieId)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJson(w, errors.New("movie not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	newId, err := app.Db.InsertReview(review)
+	if errors.Is(err, models.ErrDuplicateReview) {
+		app.errorJson(w, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	saved, err := app.Db.OneReview(newId)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	_ = app.writeJson(w, http.StatusCreated, saved)
+}
+
+// UpdateReview edits a review. Users can only edit their own.
+func (app *application) UpdateReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.ownReview(w, r)
+	if !ok {
+		return
+	}
+
+	var input reviewInput
+	err := app.readJson(w, r, &input)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	review.Rating = input.Rating
+	review.Body = input.Body
+	review.UpdatedAt = time.Now()
+
+	v := validator.New()
+	models.ValidateReview(v, review)
+	if !v.Valid() {
+		app.failedValidation(w, v.Errors)
+		return
+	}
+
+	err = app.Db.UpdateReview(*review)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	_ = app.writeJson(w, http.StatusOK, review)
+}
+
+// DeleteReview removes a review. Users can only delete their own.
+func (app *application) DeleteReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.ownReview(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Db.DeleteReview(review.Id)
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return
+	}
+
+	resp := JsonResponse{
+		Error:   false,
+		Message: "review deleted",
+	}
+	_ = app.writeJson(w, http.StatusAccepted, resp)
+}
+
+// ownReview loads the review in the URL, if it belongs to the signed in
+// user. Otherwise it responds with an error and returns false.
+func (app *application) ownReview(w http.ResponseWriter, r *http.Request) (*models.Review, bool) {
+	reviewId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return nil, false
+	}
+
+	review, err := app.Db.OneReview(reviewId)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJson(w, errors.New("review not found"), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.errorJson(w, err)
+		return nil, false
+	}
+
+	if review.UserId != requestUserId(r) {
+		app.errorJson(w, errors.New("forbidden"), http.StatusForbidden)
+		return nil, false
+	}
+
+	return review, true
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"backend/internal/models"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -12,6 +13,10 @@ func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	// configure the middleware
+	//   tags, logs and measures every request
+	mux.Use(app.requestId)
+	mux.Use(app.logRequests)
+	mux.Use(app.measureRequests)
 	//   handles 500 errors
 	mux.Use(middleware.Recoverer)
 	mux.Use(app.enableCors)
@@ -19,26 +24,79 @@ func (app *application) routes() http.Handler {
 	// add routes
 	mux.Get("/", app.Home)
 	mux.Get("/movies", app.AllMovies)
+	mux.Get("/movies/search", app.SearchMovies)
 	mux.Get("/movies/{id}", app.GetMovie)
+	mux.Get("/movies/{id}/reviews", app.MovieReviews)
+
+	mux.Get("/people/{id}", app.GetPerson)
 
 	mux.Get("/genres", app.AllGenres)
 	mux.Get("/movies/genres/{id}", app.AllMoviesByGenre)
 
+	mux.Get("/graph", app.MoviesGraphQl)
 	mux.Post("/graph", app.MoviesGraphQl)
 
+	mux.Post("/register", app.register)
+	mux.Get("/verify", app.verifyEmail)
+	mux.Post("/verify/resend", app.resendVerification)
+	mux.Post("/password/forgot", app.forgotPassword)
+	mux.Post("/password/reset", app.resetPassword)
 	mux.Post("/authenticate", app.authenticate)
 	mux.Get("/refresh", app.refreshToken)
 	mux.Get("/logout", app.logout)
+	mux.Get("/.well-known/jwks.json", app.jwks)
+
+	// signed in users review movies and keep lists of them
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.authRequired)
+
+		mux.Post("/movies/{id}/reviews", app.InsertReview)
+		mux.Put("/reviews/{id}", app.UpdateReview)
+		mux.Delete("/reviews/{id}", app.DeleteReview)
+
+		mux.Route("/me/"+listPattern, func(mux chi.Router) {
+			mux.Get("/", app.MovieList)
+			mux.Put("/order", app.ReorderList)
+			mux.Put("/{movieId}", app.AddToList)
+			mux.Patch("/{movieId}", app.MarkWatched)
+			mux.Delete("/{movieId}", app.RemoveFromList)
+		})
+	})
 
 	// Route group. All routes in here will have authRequired active
 	mux.Route("/admin", func(mux chi.Router) {
 		mux.Use(app.authRequired)
 
-		mux.Get("/movies", app.movieCatalog)
-		mux.Get("/movies/{id}", app.GetMovieForEdit)
-		mux.Put("/movies/0", app.InsertMovie)
-		mux.Patch("/movies/{id}", app.UpdateMovie)
-		mux.Delete("/movies/{id}", app.DeleteMovie)
+		// editors can manage movies, people and genres, only admins can delete
+		// them or merge genres
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requireRole(models.RoleEditor))
+
+			mux.Get("/movies", app.movieCatalog)
+			mux.Get("/movies/{id}", app.GetMovieForEdit)
+			mux.Put("/movies/0", app.InsertMovie)
+			mux.Patch("/movies/{id}", app.UpdateMovie)
+
+			mux.Get("/people", app.AllPeople)
+			mux.Get("/people/{id}", app.GetPerson)
+			mux.Put("/people/0", app.InsertPerson)
+			mux.Patch("/people/{id}", app.UpdatePerson)
+			mux.Post("/people/{id}/credits", app.InsertCredit)
+			mux.Patch("/people/{id}/credits/{creditId}", app.UpdateCredit)
+			mux.Delete("/people/{id}/credits/{creditId}", app.DeleteCredit)
+
+			mux.Put("/genres/0", app.InsertGenre)
+			mux.Patch("/genres/{id}", app.UpdateGenre)
+		})
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requireRole(models.RoleAdmin))
+
+			mux.Delete("/movies/{id}", app.DeleteMovie)
+			mux.Delete("/people/{id}", app.DeletePerson)
+			mux.Delete("/genres/{id}", app.DeleteGenre)
+			mux.Post("/genres/{id}/merge", app.MergeGenres)
+		})
 	})
 
 	return mux
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"backend/internal/config"
+	"backend/internal/repository/dbrepo"
+	"errors"
+)
+
+// runSeed runs the seed subcommand, which loads the sample genres, movies
+// and users of -fixtures into the empty database of -dsn. The users can log
+// in with well-known passwords, so it refuses to run in production.
+func (app *application) runSeed(args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: api [flags] seed")
+	}
+	if app.Env == config.EnvProduction {
+		return errors.New("the sample data is not for production")
+	}
+
+	conn, err := app.connectToDb()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = dbrepo.Seed(&dbrepo.PostgresDbRepo{Db: conn}, app.Fixtures)
+	if err != nil {
+		return err
+	}
+
+	app.Logger.Info("loaded sample data", "fixtures", app.Fixtures)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"backend/internal/jsonlog"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// serve runs the web server until ctx is done, e.g. on SIGTERM. It then
+// stops accepting connections and waits, for at most ShutdownTimeout, for
+// in-flight requests and then background tasks to finish.
+//
+// Metrics are served by a second server on MetricsAddr, which is meant to
+// be reachable only from inside, e.g. by Prometheus.
+func (app *application) serve(ctx context.Context) error {
+	srv := app.newServer(fmt.Sprintf(":%d", app.Port), app.routes())
+
+	errs := make(chan error, 2)
+	go func() {
+		app.Logger.Info("starting server", "port", app.Port, "env", app.Env)
+		errs <- srv.ListenAndServe()
+	}()
+
+	var metricsSrv *http.Server
+	if app.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", app.Metrics.handler())
+		metricsSrv = app.newServer(app.MetricsAddr, mux)
+
+		go func() {
+			app.Logger.Info("starting metrics server", "addr", app.MetricsAddr)
+			err := metricsSrv.ListenAndServe()
+			if err != nil {
+				err = fmt.Errorf("metrics server: %w", err)
+			}
+			errs <- err
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		// the server could not start, e.g. because the port is taken
+		return err
+	case <-ctx.Done():
+	}
+
+	app.Logger.Info("shutting down", "timeout", app.ShutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	if metricsSrv != nil {
+		// nothing waits on a scrape, so there is no need to drain it
+		metricsSrv.Close()
+	}
+
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		return fmt.Errorf("draining connections: %w", err)
+	}
+
+	// requests may have started background tasks, such as sending mail
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		return errors.New("background tasks did not finish in time")
+	}
+
+	app.Logger.Info("stopped")
+	return nil
+}
+
+// newServer is an HTTP server on addr with the configured timeouts
+func (app *application) newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: app.ReadHeaderTimeout,
+		ReadTimeout:       app.ReadTimeout,
+		WriteTimeout:      app.WriteTimeout,
+		IdleTimeout:       app.IdleTimeout,
+		ErrorLog:          app.Logger.StdLogger(jsonlog.LevelError),
+	}
+}
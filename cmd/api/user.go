@@ -0,0 +1,52 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository/dbrepo"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const userUsage = "usage: api [flags] user role EMAIL viewer|editor|admin"
+
+// runUser runs the user subcommand against the database of -dsn:
+//
+//	user role EMAIL ROLE  give the user with EMAIL the role ROLE
+//
+// Signed in users get the new role when their access token is next
+// refreshed, within -token-expiry.
+func (app *application) runUser(args []string) error {
+	if len(args) != 3 || args[0] != "role" {
+		return errors.New(userUsage)
+	}
+
+	email, role := normalizeEmail(args[1]), args[2]
+	if !models.ValidRole(role) {
+		return errors.New(userUsage)
+	}
+
+	conn, err := app.connectToDb()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	repo := &dbrepo.PostgresDbRepo{Db: conn}
+
+	user, err := repo.GetUserByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user has the email %s", email)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = repo.UpdateUserRole(user.Id, role)
+	if err != nil {
+		return err
+	}
+
+	app.Logger.Info("changed role", "email", user.Email, "from", user.Role, "to", role)
+	return nil
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"backend/internal/jsonlog"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 )
@@ -11,6 +14,9 @@ type JsonResponse struct {
 	Error   bool   `json:"error"`
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
+
+	// RequestId is sent with errors, for finding them in the logs
+	RequestId string `json:"request_id,omitempty"`
 }
 
 func (app *application) writeJson(w http.ResponseWriter, status int, data any, headers ...http.Header) error {
@@ -19,13 +25,14 @@ func (app *application) writeJson(w http.ResponseWriter, status int, data any, h
 		return err
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+
 	if len(headers) > 0 {
 		for key, value := range headers[0] {
 			w.Header()[key] = value
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_, err = w.Write(out)
 	if err != nil {
@@ -70,6 +77,57 @@ func (app *application) errorJson(w http.ResponseWriter, err error, status ...in
 	var payload JsonResponse
 	payload.Error = true
 	payload.Message = err.Error()
+	payload.RequestId = w.Header().Get("X-Request-ID")
 
 	return app.writeJson(w, statusCode, payload)
 }
+
+// failedValidation responds with the per-field errors of a validator
+func (app *application) failedValidation(w http.ResponseWriter, errors map[string]string) error {
+	payload := JsonResponse{
+		Error:     true,
+		Message:   "invalid input",
+		Data:      errors,
+		RequestId: w.Header().Get("X-Request-ID"),
+	}
+
+	return app.writeJson(w, http.StatusUnprocessableEntity, payload)
+}
+
+// background runs fn in a goroutine that is waited for on shutdown, and
+// logs rather than crashes on a panic
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.Logger.Error("background task panicked", "panic", fmt.Sprint(err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// requestLogger returns the logger with the ID of a request added
+func (app *application) requestLogger(r *http.Request) *jsonlog.Logger {
+	return app.contextLogger(r.Context())
+}
+
+// contextLogger is requestLogger for code that is handed the context of a
+// request rather than the request, such as GraphQL resolvers
+func (app *application) contextLogger(ctx context.Context) *jsonlog.Logger {
+	if info, ok := ctx.Value(requestInfoContextKey).(*requestInfo); ok {
+		return app.Logger.With("request_id", info.Id)
+	}
+
+	return app.Logger
+}
+
+// logError logs an error that happened while serving a request
+func (app *application) logError(r *http.Request, err error) {
+	app.requestLogger(r).Error(err.Error(), "method", r.Method, "path", r.URL.Path)
+}
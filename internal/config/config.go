@@ -0,0 +1,437 @@
+package config
+
+import (
+	"backend/internal/graph"
+	"backend/internal/jsonlog"
+	"backend/internal/keyring"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Environments the API can run in
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// EnvPrefix starts the name of the environment variable of every setting,
+// e.g. API_JWT_SECRET for -jwt-secret
+const EnvPrefix = "API_"
+
+// MinJwtSecret is the shortest jwt-secret allowed in production, in bytes;
+// HS256 is only as strong as its 256 bit key
+const MinJwtSecret = 32
+
+// Sources of a setting, from lowest to highest precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// secrets are the settings that are never printed
+var secrets = map[string]bool{
+	"dsn":           true,
+	"jwt-secret":    true,
+	"smtp-password": true,
+	"tmdb-api-key":  true,
+}
+
+// Config holds the settings of the API. Every setting has a flag, an
+// environment variable and a key in the config file, which all share its
+// name: -jwt-secret, API_JWT_SECRET and jwt-secret (or jwt: secret:).
+type Config struct {
+	Env      string
+	LogLevel string
+	Port     int
+	// MetricsAddr is where /metrics is served, apart from the API so
+	// that it is not public. Empty turns metrics off.
+	MetricsAddr string
+	Repo        string
+	Fixtures    string
+	Dsn         string
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+
+	Domain      string
+	BaseUrl     string
+	FrontendUrl string
+	CorsOrigins []string
+
+	JwtSecret     string
+	JwtIssuer     string
+	JwtAudience   string
+	JwtAlgorithm  string
+	JwtKeyDir     string
+	JwtRotation   time.Duration
+	JwtRetention  time.Duration
+	TokenExpiry   time.Duration
+	RefreshExpiry time.Duration
+	CookieDomain  string
+
+	TmdbApiKey string
+
+	MailerType   string
+	MailDir      string
+	MailFrom     string
+	SmtpHost     string
+	SmtpPort     int
+	SmtpUsername string
+	SmtpPassword string
+
+	GraphLimits    graph.Limits
+	GraphManifest  string
+	GraphAllowlist bool
+
+	fs      *flag.FlagSet
+	sources map[string]string
+}
+
+// register defines the flag of every setting, with its default
+func (c *Config) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.Env, "env", EnvDevelopment, "environment (development or production)")
+	fs.StringVar(&c.LogLevel, "log-level", "info", "least severe level to log (debug, info, warn or error)")
+	fs.IntVar(&c.Port, "port", 8080, "port to listen on")
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", "localhost:9090", "internal address to serve /metrics on, or empty to not serve it")
+	fs.StringVar(&c.Repo, "repo", "postgres", "repository to use (postgres or memory)")
+	fs.StringVar(&c.Fixtures, "fixtures", "./sql/seed.json", "seed file for the memory repository and the seed command")
+	fs.StringVar(&c.Dsn, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=movies sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection string")
+
+	fs.DurationVar(&c.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "longest a client may take to send the request headers")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", 15*time.Second, "longest a client may take to send a whole request")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", 30*time.Second, "longest a request may take from the end of its headers to the end of the response")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", time.Minute, "longest a keep-alive connection may wait for the next request")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 20*time.Second, "longest to wait for requests and background tasks to finish on shutdown")
+
+	fs.StringVar(&c.Domain, "domain", "example.com", "domain")
+	fs.StringVar(&c.BaseUrl, "base-url", "http://localhost:8080", "public URL of the API, used in emailed links")
+	fs.StringVar(&c.FrontendUrl, "frontend-url", "http://localhost:3000", "URL of the frontend, used in emailed links")
+	c.CorsOrigins = []string{"http://localhost:3000"}
+	fs.Var((*listValue)(&c.CorsOrigins), "cors-origins", "comma separated origins allowed to call the API from a browser")
+
+	fs.StringVar(&c.JwtSecret, "jwt-secret", "development-secret", "signing secret")
+	fs.StringVar(&c.JwtIssuer, "jwt-issuer", "example.com", "signing issuer")
+	fs.StringVar(&c.JwtAudience, "jwt-audience", "example.com", "signing audience")
+	fs.StringVar(&c.JwtAlgorithm, "jwt-alg", keyring.HS256, "signing algorithm (HS256, RS256 or ES256)")
+	fs.StringVar(&c.JwtKeyDir, "jwt-key-dir", "", "directory to keep RS256/ES256 signing keys in")
+	fs.DurationVar(&c.JwtRotation, "jwt-key-rotation", 30*24*time.Hour, "how often to rotate RS256/ES256 signing keys, 0 to never")
+	fs.DurationVar(&c.JwtRetention, "jwt-key-retention", 48*time.Hour, "how long a rotated key still verifies tokens")
+	fs.DurationVar(&c.TokenExpiry, "token-expiry", 15*time.Minute, "lifetime of access tokens")
+	fs.DurationVar(&c.RefreshExpiry, "refresh-expiry", 24*time.Hour, "lifetime of refresh tokens")
+	fs.StringVar(&c.CookieDomain, "cookie-domain", "localhost", "cookie domain")
+
+	fs.StringVar(&c.TmdbApiKey, "tmdb-api-key", "", "api key")
+
+	fs.StringVar(&c.MailerType, "mailer", "log", "how to send mail (log or smtp)")
+	fs.StringVar(&c.MailDir, "mail-dir", "", "directory the log mailer writes messages to, instead of the log")
+	fs.StringVar(&c.MailFrom, "mail-from", "Go Movies <no-reply@example.com>", "sender of outgoing mail")
+	fs.StringVar(&c.SmtpHost, "smtp-host", "localhost", "SMTP host")
+	fs.IntVar(&c.SmtpPort, "smtp-port", 25, "SMTP port")
+	fs.StringVar(&c.SmtpUsername, "smtp-username", "", "SMTP username")
+	fs.StringVar(&c.SmtpPassword, "smtp-password", "", "SMTP password")
+
+	c.GraphLimits = graph.DefaultLimits
+	fs.IntVar(&c.GraphLimits.MaxDepth, "graph-max-depth", c.GraphLimits.MaxDepth, "deepest nesting of a GraphQL query, 0 for no limit")
+	fs.IntVar(&c.GraphLimits.MaxComplexity, "graph-max-complexity", c.GraphLimits.MaxComplexity, "highest complexity of a GraphQL query, 0 for no limit")
+	fs.IntVar(&c.GraphLimits.MaxAliases, "graph-max-aliases", c.GraphLimits.MaxAliases, "most aliases in a GraphQL query, 0 for no limit")
+	fs.DurationVar(&c.GraphLimits.Timeout, "graph-timeout", c.GraphLimits.Timeout, "longest a GraphQL query may run, 0 for no limit")
+	fs.StringVar(&c.GraphManifest, "graph-manifest", "", "persisted query manifest to load at startup")
+	fs.BoolVar(&c.GraphAllowlist, "graph-allowlist", false, "only run the GraphQL queries of the manifest")
+}
+
+// Load reads the settings. Each one is taken from the first of these that
+// has it:
+//
+//  1. the command line flag
+//  2. the environment variable
+//  3. the config file, given by -config or API_CONFIG
+//  4. the default
+//
+// environ is the environment, as returned by os.Environ. The settings are
+// not validated; call Validate for that.
+func Load(name string, args []string, environ []string) (*Config, error) {
+	c := &Config{sources: make(map[string]string)}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	c.register(fs)
+	c.fs = fs
+
+	var file string
+	fs.StringVar(&file, "config", "", "YAML or TOML file to read settings from")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", name)
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nEvery setting can also be given in the environment, as %sJWT_SECRET for -jwt-secret,\nor in the -config file, as jwt-secret or jwt: secret:. Flags come first, then the\nenvironment, then the file.\n", EnvPrefix)
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		c.sources[f.Name] = SourceFlag
+	})
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		k, v, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(k, EnvPrefix) {
+			env[k] = v
+		}
+	}
+
+	if file == "" {
+		file = env[EnvPrefix+"CONFIG"]
+	}
+
+	var fileValues map[string]string
+	if file != "" {
+		fileValues, err = readFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for key := range fileValues {
+			if key == "config" || fs.Lookup(key) == nil {
+				return nil, fmt.Errorf("%s: unknown setting %q", file, key)
+			}
+		}
+	}
+
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || c.sources[f.Name] == SourceFlag {
+			return
+		}
+
+		source := SourceDefault
+		value, ok := env[envName(f.Name)]
+		if ok {
+			source = SourceEnv
+		} else if value, ok = fileValues[f.Name]; ok {
+			source = SourceFile
+		}
+
+		if ok {
+			err := f.Value.Set(value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("invalid value %q for %s from %s: %v", value, f.Name, source, err))
+			}
+		}
+		c.sources[f.Name] = source
+	})
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+
+	return c, nil
+}
+
+// Args are the arguments left after the flags, such as a subcommand
+func (c *Config) Args() []string {
+	return c.fs.Args()
+}
+
+// Validate checks the settings, and reports every problem at once
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Env == EnvDevelopment || c.Env == EnvProduction, "env must be %s or %s", EnvDevelopment, EnvProduction)
+	_, err := jsonlog.ParseLevel(c.LogLevel)
+	check(err == nil, "log-level must be debug, info, warn or error")
+	check(c.Port > 0 && c.Port < 65536, "port must be between 1 and 65535")
+	if c.MetricsAddr != "" {
+		_, _, err = net.SplitHostPort(c.MetricsAddr)
+		check(err == nil, "metrics-addr must be a host:port such as localhost:9090")
+	}
+	check(c.Repo == "postgres" || c.Repo == "memory", "repo must be postgres or memory")
+
+	check(c.ReadHeaderTimeout > 0, "read-header-timeout must be positive")
+	check(c.ReadTimeout >= c.ReadHeaderTimeout, "read-timeout must be at least read-header-timeout")
+	check(c.WriteTimeout > 0, "write-timeout must be positive")
+	check(c.IdleTimeout > 0, "idle-timeout must be positive")
+	check(c.ShutdownTimeout > 0, "shutdown-timeout must be positive")
+
+	check(isUrl(c.BaseUrl), "base-url must be an absolute http or https URL")
+	check(isUrl(c.FrontendUrl), "frontend-url must be an absolute http or https URL")
+	for _, origin := range c.CorsOrigins {
+		u, err := url.Parse(origin)
+		check(err == nil && isUrl(origin) && u.Path == "" && u.RawQuery == "", "cors-origins: %q is not an origin such as https://example.com", origin)
+	}
+
+	check(c.JwtAlgorithm == keyring.HS256 || c.JwtAlgorithm == keyring.RS256 || c.JwtAlgorithm == keyring.ES256, "jwt-alg must be HS256, RS256 or ES256")
+	check(c.TokenExpiry > 0, "token-expiry must be positive")
+	check(c.RefreshExpiry > c.TokenExpiry, "refresh-expiry must be longer than token-expiry")
+	check(c.JwtRotation >= 0, "jwt-key-rotation must not be negative")
+	if c.JwtAlgorithm != keyring.HS256 {
+		// refresh tokens are signed with the keys too, and live longest
+		check(c.JwtRetention >= c.RefreshExpiry, "jwt-key-retention must be at least refresh-expiry, or refresh tokens stop verifying before they expire")
+	}
+
+	check(c.MailerType == "log" || c.MailerType == "smtp", "mailer must be log or smtp")
+	_, err = mail.ParseAddress(c.MailFrom)
+	check(err == nil, "mail-from must be an address such as \"Go Movies <no-reply@example.com>\"")
+	if c.MailerType == "smtp" {
+		check(c.SmtpPort > 0 && c.SmtpPort < 65536, "smtp-port must be between 1 and 65535")
+	}
+
+	check(c.GraphLimits.MaxDepth >= 0, "graph-max-depth must not be negative")
+	check(c.GraphLimits.MaxComplexity >= 0, "graph-max-complexity must not be negative")
+	check(c.GraphLimits.MaxAliases >= 0, "graph-max-aliases must not be negative")
+	check(c.GraphLimits.Timeout >= 0, "graph-timeout must not be negative")
+	check(c.GraphLimits.Timeout < c.WriteTimeout, "graph-timeout must be shorter than write-timeout, or slow queries are cut off without an answer")
+	check(!c.GraphAllowlist || c.GraphManifest != "", "graph-allowlist needs a graph-manifest")
+
+	// the defaults and the sample users are in the source code, so anyone
+	// could sign tokens, log in to the database or log in as the admin
+	// with them
+	if c.Env == EnvProduction {
+		check(c.Repo != "memory", "repo must be postgres in production, since the memory repository holds the sample users")
+		if c.JwtAlgorithm == keyring.HS256 {
+			check(len(c.JwtSecret) >= MinJwtSecret && !c.IsDefault("jwt-secret"), "jwt-secret must be set to at least %d bytes in production", MinJwtSecret)
+		} else {
+			// without a directory the keys only live in memory, so every
+			// restart logs everyone out and instances reject each other's tokens
+			check(c.JwtKeyDir != "", "jwt-key-dir must be set in production with %s", c.JwtAlgorithm)
+		}
+		if c.Repo == "postgres" {
+			check(!c.IsDefault("dsn"), "dsn must be set in production")
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
+
+// IsDefault reports whether a setting still has its default value, however
+// it was set
+func (c *Config) IsDefault(name string) bool {
+	f := c.fs.Lookup(name)
+	return f != nil && f.Value.String() == f.DefValue
+}
+
+// Setting is the effective value of a setting and where it came from
+type Setting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Settings returns every setting by name, for logging the effective config.
+// Secrets that are set are shown as [redacted].
+func (c *Config) Settings() map[string]Setting {
+	settings := make(map[string]Setting)
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+
+		value := f.Value.String()
+		if secrets[f.Name] && value != "" {
+			value = "[redacted]"
+		}
+		settings[f.Name] = Setting{Value: value, Source: c.sources[f.Name]}
+	})
+
+	return settings
+}
+
+// envName is the environment variable of a setting
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// readFile reads a YAML or TOML config file, chosen by its extension, into
+// setting names and values. Nested tables join their keys with dashes, so
+// jwt: secret: is the jwt-secret setting.
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &doc)
+	case ".toml":
+		err = toml.Unmarshal(b, &doc)
+	default:
+		return nil, fmt.Errorf("%s: config files must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flatten(values, "", doc)
+
+	return values, nil
+}
+
+func flatten(values map[string]string, prefix string, doc map[string]any) {
+	for key, value := range doc {
+		key = prefix + strings.ReplaceAll(key, "_", "-")
+
+		switch value := value.(type) {
+		case map[string]any:
+			flatten(values, key+"-", value)
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+}
+
+func isUrl(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// listValue is a flag holding a comma separated list
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			*l = append(*l, item)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// strongSecret is long enough for production
+const strongSecret = "0123456789abcdef0123456789abcdef"
+
+// writeFile writes a config file into a temporary directory
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func load(t *testing.T, args []string, environ []string) *Config {
+	t.Helper()
+
+	c, err := Load("api", args, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestPrecedence(t *testing.T) {
+	file := writeFile(t, "api.yaml", `
+port: 1000
+token-expiry: 1m
+jwt:
+  issuer: file.example.com
+`)
+
+	c := load(t,
+		[]string{"-config", file, "-port", "3000"},
+		[]string{"API_PORT=2000", "API_TOKEN_EXPIRY=2m", "PORT=4000"},
+	)
+
+	if c.Port != 3000 {
+		t.Errorf("port is %d, want the flag's 3000", c.Port)
+	}
+	if c.TokenExpiry != 2*time.Minute {
+		t.Errorf("token-expiry is %s, want the environment's 2m", c.TokenExpiry)
+	}
+	if c.JwtIssuer != "file.example.com" {
+		t.Errorf("jwt-issuer is %q, want the file's file.example.com", c.JwtIssuer)
+	}
+	if c.JwtAudience != "example.com" {
+		t.Errorf("jwt-audience is %q, want the default example.com", c.JwtAudience)
+	}
+
+	settings := c.Settings()
+	for name, source := range map[string]string{
+		"port":         SourceFlag,
+		"token-expiry": SourceEnv,
+		"jwt-issuer":   SourceFile,
+		"jwt-audience": SourceDefault,
+	} {
+		if settings[name].Source != source {
+			t.Errorf("%s comes from %q, want %q", name, settings[name].Source, source)
+		}
+	}
+}
+
+func TestConfigFromEnvironment(t *testing.T) {
+	file := writeFile(t, "api.toml", `
+[jwt]
+issuer = "toml.example.com"
+`)
+
+	c := load(t, nil, []string{"API_CONFIG=" + file})
+	if c.JwtIssuer != "toml.example.com" {
+		t.Errorf("jwt-issuer is %q, want the file's toml.example.com", c.JwtIssuer)
+	}
+}
+
+func TestUnknownFileSetting(t *testing.T) {
+	file := writeFile(t, "api.yaml", "jwt-secrets: oops\n")
+
+	_, err := Load("api", []string{"-config", file}, nil)
+	if err == nil || !strings.Contains(err.Error(), `unknown setting "jwt-secrets"`) {
+		t.Errorf("got %v, want an unknown setting error", err)
+	}
+}
+
+func TestProduction(t *testing.T) {
+	production := []string{
+		"-env=production",
+		"-dsn=host=db user=api",
+		"-jwt-secret=" + strongSecret,
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"valid", nil, ""},
+		{"memory repository", []string{"-repo=memory"}, "repo must be postgres in production"},
+		{"default secret", []string{"-jwt-secret=development-secret"}, "jwt-secret must be set"},
+		{"empty secret", []string{"-jwt-secret="}, "jwt-secret must be set"},
+		{"short secret", []string{"-jwt-secret=x"}, "jwt-secret must be set"},
+		{"keys in memory", []string{"-jwt-alg=ES256"}, "jwt-key-dir must be set"},
+		{"keys in a directory", []string{"-jwt-alg=RS256", "-jwt-key-dir=/var/lib/api/keys", "-jwt-key-retention=24h"}, ""},
+		{"default dsn", []string{"-dsn=host=localhost port=5432 user=postgres password=postgres dbname=movies sslmode=disable timezone=UTC connect_timeout=5"}, "dsn must be set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// later flags override the production ones
+			c := load(t, append(append([]string{}, production...), tt.args...), nil)
+
+			err := c.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDevelopmentAllowsDefaults(t *testing.T) {
+	c := load(t, []string{"-repo=memory"}, nil)
+
+	err := c.Validate()
+	if err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+}
+
+func TestSettingsRedactsSecrets(t *testing.T) {
+	c := load(t, []string{"-jwt-secret=" + strongSecret}, []string{"API_DSN=host=db password=hunter2"})
+
+	settings := c.Settings()
+	for _, name := range []string{"jwt-secret", "dsn"} {
+		if settings[name].Value != "[redacted]" {
+			t.Errorf("%s is shown as %q, want [redacted]", name, settings[name].Value)
+		}
+	}
+
+	// an unset secret is shown as empty, so that it is clear it is missing
+	if settings["smtp-password"].Value != "" {
+		t.Errorf("smtp-password is shown as %q, want it empty", settings["smtp-password"].Value)
+	}
+	if settings["jwt-issuer"].Value != "example.com" {
+		t.Errorf("jwt-issuer is shown as %q, want example.com", settings["jwt-issuer"].Value)
+	}
+	if _, ok := settings["config"]; ok {
+		t.Error("config is listed as a setting")
+	}
+}
@@ -0,0 +1,18 @@
+package graph
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+)
+
+//go:embed graphiql.html
+var graphiqlPage string
+
+var graphiqlTemplate = template.Must(template.New("graphiql").Parse(graphiqlPage))
+
+// GraphiQL writes an in-browser IDE that sends its requests to endpoint.
+// It is meant for development only.
+func GraphiQL(w io.Writer, endpoint string) error {
+	return graphiqlTemplate.Execute(w, struct{ Endpoint string }{endpoint})
+}
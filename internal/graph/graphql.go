@@ -2,23 +2,49 @@ package graph
 
 import (
 	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
 	"errors"
-	"strings"
+	"fmt"
 
 	"github.com/graphql-go/graphql"
 )
 
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// Graph is the GraphQL schema. It is built once and shared between
+// requests; resolvers read from the repository with the request context.
 type Graph struct {
-	Movies      []*models.Movie
-	QueryString string
-	Config      graphql.SchemaConfig
-	fields      graphql.Fields
-	movieType   *graphql.Object
+	Db     repository.DatabaseRepo
+	Schema graphql.Schema
+
+	// FindPoster, if set, fills in the image of a movie being created. It
+	// is given the context of the request.
+	FindPoster func(ctx context.Context, movie models.Movie) models.Movie
+
+	// Limits bound the size and run time of a request
+	Limits Limits
+
+	// Persisted holds the queries clients can send by hash
+	Persisted *PersistedQueries
+
+	movieType *graphql.Object
+	genreType *graphql.Object
 }
 
-func New(movies []*models.Movie) *Graph {
+func New(db repository.DatabaseRepo) (*Graph, error) {
+	g := &Graph{
+		Db:        db,
+		Limits:    DefaultLimits,
+		Persisted: NewPersistedQueries(),
+	}
+
 	// The data schema. Fields match database names.
-	var movieType = graphql.NewObject(
+	g.movieType = graphql.NewObject(
 		graphql.ObjectConfig{
 			Name: "Movie",
 			Fields: graphql.Fields{
@@ -49,45 +75,133 @@ func New(movies []*models.Movie) *Graph {
 				"image": &graphql.Field{
 					Type: graphql.String,
 				},
+				"version": &graphql.Field{
+					Type:        graphql.Int,
+					Description: "Only known for a single movie, not in lists",
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						if movie, ok := p.Source.(*models.Movie); ok && movie.Version > 0 {
+							return movie.Version, nil
+						}
+						return nil, nil
+					},
+				},
 			},
 		},
 	)
 
+	g.genreType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Genre",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"genre": &graphql.Field{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	// The pagination arguments shared by lists of movies
+	pageArgs := graphql.FieldConfigArgument{
+		"page": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 1,
+		},
+		"limit": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: defaultListLimit,
+			Description:  fmt.Sprintf("At most %d", maxListLimit),
+		},
+	}
+
+	// Relations are added once both types exist, as they refer to each other.
+	// Both load in batches, one query per level of the query.
+	g.movieType.AddFieldConfig("genres", &graphql.Field{
+		Type:        graphql.NewList(g.genreType),
+		Description: "Genres of the movie, ordered by name",
+		Resolve: func(params graphql.ResolveParams) (any, error) {
+			movie, ok := params.Source.(*models.Movie)
+			if !ok {
+				return nil, nil
+			}
+
+			// single movies come with their genres already
+			if movie.Genres != nil {
+				return movie.Genres, nil
+			}
+
+			return g.loadersFrom(params.Context).movieGenres.load(movie.Id), nil
+		},
+	})
+
+	g.genreType.AddFieldConfig("movies", &graphql.Field{
+		Type:        graphql.NewList(g.movieType),
+		Description: "A page of the movies in the genre, ordered by title",
+		Args:        pageArgs,
+		Resolve: func(params graphql.ResolveParams) (any, error) {
+			genre, ok := params.Source.(*models.Genre)
+			if !ok {
+				return nil, nil
+			}
+
+			q, err := movieQuery(params.Args)
+			if err != nil {
+				return nil, err
+			}
+
+			return g.loadersFrom(params.Context).genreMoviesPage(q.Page, q.Limit).load(genre.Id), nil
+		},
+	})
+
+	searchArgs := graphql.FieldConfigArgument{
+		"titleContains": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+	}
+	for name, arg := range pageArgs {
+		searchArgs[name] = arg
+	}
+
 	// The actions that can be carried out on the data
 	var fields = graphql.Fields{
 		"list": &graphql.Field{
-			Type:        graphql.NewList(movieType),
-			Description: "Get all movies",
+			Type:        graphql.NewList(g.movieType),
+			Description: "Get a page of movies, ordered by title",
+			Args:        pageArgs,
 			Resolve: func(params graphql.ResolveParams) (any, error) {
-				return movies, nil
+				q, err := movieQuery(params.Args)
+				if err != nil {
+					return nil, err
+				}
+
+				return g.filterMovies(params.Context, q)
 			},
 		},
 
 		"search": &graphql.Field{
-			Type:        graphql.NewList(movieType),
+			Type:        graphql.NewList(g.movieType),
 			Description: "Search movies by title",
-			Args: graphql.FieldConfigArgument{
-				"titleContains": &graphql.ArgumentConfig{
-					Type: graphql.String,
-				},
-			},
+			Args:        searchArgs,
 			Resolve: func(params graphql.ResolveParams) (any, error) {
-				var list []*models.Movie
 				search, ok := params.Args["titleContains"].(string)
-				if ok {
-					for _, currentMovie := range movies {
-						if strings.Contains(strings.ToLower(currentMovie.Title), strings.ToLower(search)) {
-							list = append(list, currentMovie)
-						}
-					}
+				if !ok || search == "" {
+					return nil, nil
 				}
 
-				return list, nil
+				q, err := movieQuery(params.Args)
+				if err != nil {
+					return nil, err
+				}
+				q.TitleContains = search
+
+				return g.filterMovies(params.Context, q)
 			},
 		},
 
 		"get": &graphql.Field{
-			Type:        graphql.NewList(movieType),
+			Type:        g.movieType,
 			Description: "Get movie by id",
 			Args: graphql.FieldConfigArgument{
 				"id": &graphql.ArgumentConfig{
@@ -96,51 +210,149 @@ func New(movies []*models.Movie) *Graph {
 			},
 			Resolve: func(params graphql.ResolveParams) (any, error) {
 				id, ok := params.Args["id"].(int)
-				if ok {
-					for _, currentMovie := range movies {
-						if currentMovie.Id == id {
-							return currentMovie, nil
-						}
-					}
+				if !ok {
+					return nil, nil
 				}
 
-				return nil, nil
+				movie, err := g.db(params.Context).OneMovie(id)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				return movie, nil
 			},
 		},
-	}
 
-	return &Graph{
-		Movies:    movies,
-		fields:    fields,
-		movieType: movieType,
+		"genres": &graphql.Field{
+			Type:        graphql.NewList(g.genreType),
+			Description: "Get all genres, ordered by name",
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				return g.db(params.Context).AllGenres()
+			},
+		},
+
+		"genre": &graphql.Field{
+			Type:        g.genreType,
+			Description: "Get genre by id",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				id, _ := params.Args["id"].(int)
+
+				genre, err := g.db(params.Context).OneGenre(id)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				return genre, nil
+			},
+		},
 	}
-}
 
-func (g *Graph) Query() (*graphql.Result, error) {
 	rootQuery := graphql.ObjectConfig{
-		Name: "RootQuery",
-		Fields: g.fields,
+		Name:   "RootQuery",
+		Fields: fields,
+	}
+
+	rootMutation := graphql.ObjectConfig{
+		Name:   "RootMutation",
+		Fields: g.newMutationFields(g.movieType),
 	}
 
 	schemaConfig := graphql.SchemaConfig{
-		Query: graphql.NewObject(rootQuery),
+		Query:    graphql.NewObject(rootQuery),
+		Mutation: graphql.NewObject(rootMutation),
 	}
 
 	schema, err := graphql.NewSchema(schemaConfig)
 	if err != nil {
 		return nil, err
 	}
+	g.Schema = schema
+
+	return g, nil
+}
+
+// Do runs a request. ctx carries the Viewer, if the request was
+// authenticated, which mutations need.
+//
+// Errors are reported in the result, next to whatever data resolved, as the
+// GraphQL spec describes.
+//
+// Requests over the limits are rejected before they run.
+func (g *Graph) Do(ctx context.Context, req Request) *graphql.Result {
+	if errs := g.checkLimits(req); len(errs) > 0 {
+		return &graphql.Result{Errors: errs}
+	}
+
+	if g.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Limits.Timeout)
+		defer cancel()
+	}
+
+	// batch loaders live for one request
+	ctx = withLoaders(ctx, newLoaders(g.db(ctx)))
 
 	params := graphql.Params{
-		Schema: schema,
-		RequestString: g.QueryString,
+		Schema:         g.Schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	}
+
+	return graphql.Do(params)
+}
+
+// db returns the repository bound to the context of the request
+func (g *Graph) db(ctx context.Context) repository.DatabaseRepo {
+	if ctx == nil {
+		return g.Db
+	}
+
+	return g.Db.WithContext(ctx)
+}
+
+func (g *Graph) filterMovies(ctx context.Context, q models.MovieQuery) ([]*models.Movie, error) {
+	page, err := g.db(ctx).FilterMovies(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return page.Movies, nil
+}
+
+// movieQuery reads the pagination arguments of a list of movies
+func movieQuery(args map[string]any) (models.MovieQuery, error) {
+	q := models.MovieQuery{
+		Sort:  models.SortTitle,
+		Page:  1,
+		Limit: defaultListLimit,
 	}
 
-	response := graphql.Do(params)
+	if page, ok := args["page"].(int); ok {
+		q.Page = page
+	}
+	if limit, ok := args["limit"].(int); ok {
+		q.Limit = limit
+	}
 
-	if len(response.Errors) > 0 {
-		return nil, errors.New("error executing query")
+	if q.Page < 1 {
+		return q, errors.New("page must be 1 or more")
+	}
+	if q.Limit < 1 || q.Limit > maxListLimit {
+		return q, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
 	}
 
-	return response, nil
-}
\ No newline at end of file
+	return q, nil
+}
@@ -0,0 +1,310 @@
+package graph
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// Limits bound the work a single request can ask for. Zero values mean
+// no limit.
+type Limits struct {
+	MaxDepth      int
+	MaxComplexity int
+	MaxAliases    int
+	Timeout       time.Duration
+}
+
+// DefaultLimits allow any query the frontend sends, but not the
+// nesting of relations many levels deep.
+var DefaultLimits = Limits{
+	MaxDepth:      8,
+	MaxComplexity: 5000,
+	MaxAliases:    20,
+	Timeout:       10 * time.Second,
+}
+
+// fieldCosts is the cost of resolving a field, by type and field name.
+// Fields not listed cost 1. The cost of a list field's selections is
+// multiplied by its limit argument, or by listSize for lists without one.
+var fieldCosts = map[string]int{
+	"RootQuery.list":   2,
+	"RootQuery.search": 5,
+	"Movie.genres":     2,
+	"Genre.movies":     2,
+
+	"RootMutation.createMovie":    10,
+	"RootMutation.updateMovie":    10,
+	"RootMutation.deleteMovie":    10,
+	"RootMutation.setMovieGenres": 10,
+}
+
+// listSize is the number of items assumed for lists without a limit, such
+// as the genres of a movie
+const listSize = 10
+
+// maxCost caps complexity, so that huge queries cannot overflow it
+const maxCost = math.MaxInt32
+
+// queryCost is the measured size of an operation
+type queryCost struct {
+	depth      int
+	complexity int
+	aliases    int
+}
+
+// checkLimits measures the operation a request runs and reports the limits
+// it exceeds. Documents that do not parse pass; execution reports them.
+func (g *Graph) checkLimits(req Request) []gqlerrors.FormattedError {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(req.Query)}),
+	})
+	if err != nil {
+		return nil
+	}
+
+	a := analysis{
+		schema:    g.Schema,
+		variables: req.Variables,
+		defaults:  make(map[string]ast.Value),
+		fragments: make(map[string]*ast.FragmentDefinition),
+	}
+
+	var op *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.FragmentDefinition:
+			a.fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			if req.OperationName == "" || (def.Name != nil && def.Name.Value == req.OperationName) {
+				op = def
+			}
+		}
+	}
+	if op == nil {
+		return nil
+	}
+
+	// variables the request leaves out take their default value
+	for _, def := range op.VariableDefinitions {
+		if def.DefaultValue != nil {
+			a.defaults[def.Variable.Name.Value] = def.DefaultValue
+		}
+	}
+
+	var root *graphql.Object
+	switch op.Operation {
+	case ast.OperationTypeQuery:
+		root = g.Schema.QueryType()
+	case ast.OperationTypeMutation:
+		root = g.Schema.MutationType()
+	}
+
+	cost := a.selections(op.SelectionSet, root, 1, nil)
+
+	var errs []gqlerrors.FormattedError
+	if g.Limits.MaxDepth > 0 && cost.depth > g.Limits.MaxDepth {
+		errs = append(errs, limitError("query is nested %d levels deep, the limit is %d", cost.depth, g.Limits.MaxDepth))
+	}
+	if g.Limits.MaxComplexity > 0 && cost.complexity > g.Limits.MaxComplexity {
+		errs = append(errs, limitError("query has a complexity of %d, the limit is %d", cost.complexity, g.Limits.MaxComplexity))
+	}
+	if g.Limits.MaxAliases > 0 && cost.aliases > g.Limits.MaxAliases {
+		errs = append(errs, limitError("query uses %d aliases, the limit is %d", cost.aliases, g.Limits.MaxAliases))
+	}
+
+	return errs
+}
+
+func limitError(format string, args ...any) gqlerrors.FormattedError {
+	err := gqlerrors.NewFormattedError(fmt.Sprintf(format, args...))
+	err.Extensions = map[string]any{"code": "QUERY_TOO_LARGE"}
+	return err
+}
+
+// analysis walks the selections of an operation, expanding fragments
+type analysis struct {
+	schema    graphql.Schema
+	variables map[string]any
+	defaults  map[string]ast.Value
+	fragments map[string]*ast.FragmentDefinition
+}
+
+// selections measures a selection set at the given depth. parent is the
+// type the fields are selected on, nil when unknown. visiting holds the
+// fragments being expanded, to stop at cycles.
+func (a *analysis) selections(set *ast.SelectionSet, parent *graphql.Object, depth int, visiting map[string]bool) queryCost {
+	var total queryCost
+	if set == nil {
+		return total
+	}
+
+	for _, sel := range set.Selections {
+		var cost queryCost
+
+		switch sel := sel.(type) {
+		case *ast.Field:
+			cost = a.field(sel, parent, depth, visiting)
+
+		case *ast.InlineFragment:
+			typ := parent
+			if sel.TypeCondition != nil {
+				typ, _ = a.schema.Type(sel.TypeCondition.Name.Value).(*graphql.Object)
+			}
+			cost = a.selections(sel.SelectionSet, typ, depth, visiting)
+
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			fragment, ok := a.fragments[name]
+			if !ok || visiting[name] {
+				continue
+			}
+
+			inner := map[string]bool{name: true}
+			for k := range visiting {
+				inner[k] = true
+			}
+
+			typ, _ := a.schema.Type(fragment.TypeCondition.Name.Value).(*graphql.Object)
+			cost = a.selections(fragment.SelectionSet, typ, depth, inner)
+		}
+
+		total.complexity = addCost(total.complexity, cost.complexity)
+		total.aliases += cost.aliases
+		if cost.depth > total.depth {
+			total.depth = cost.depth
+		}
+	}
+
+	return total
+}
+
+func (a *analysis) field(field *ast.Field, parent *graphql.Object, depth int, visiting map[string]bool) queryCost {
+	var cost queryCost
+	if field.Alias != nil && field.Alias.Value != field.Name.Value {
+		cost.aliases = 1
+	}
+
+	// Introspection is bounded by the size of the schema
+	name := field.Name.Value
+	if strings.HasPrefix(name, "__") {
+		return cost
+	}
+
+	var def *graphql.FieldDefinition
+	if parent != nil {
+		def = parent.Fields()[name]
+	}
+
+	var child *graphql.Object
+	isList := false
+	if def != nil {
+		child, isList = unwrapType(def.Type)
+	}
+
+	inner := a.selections(field.SelectionSet, child, depth+1, visiting)
+
+	fieldCost := 1
+	if parent != nil {
+		if c, ok := fieldCosts[parent.Name()+"."+name]; ok {
+			fieldCost = c
+		}
+	}
+
+	multiplier := 1
+	if isList {
+		multiplier = a.listSize(field, def)
+	}
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	if multiplier > maxListLimit {
+		multiplier = maxListLimit
+	}
+
+	cost.depth = depth
+	if inner.depth > depth {
+		cost.depth = inner.depth
+	}
+	cost.complexity = maxCost
+	if inner.complexity < maxCost/multiplier {
+		cost.complexity = addCost(fieldCost, multiplier*inner.complexity)
+	}
+	cost.aliases += inner.aliases
+
+	return cost
+}
+
+// listSize is the number of items a list field asks for: its limit
+// argument, from the variables or their defaults when it is a variable, or
+// the default limit of paginated lists
+func (a *analysis) listSize(field *ast.Field, def *graphql.FieldDefinition) int {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+
+		value := arg.Value
+		if v, ok := value.(*ast.Variable); ok {
+			switch n := a.variables[v.Name.Value].(type) {
+			case int:
+				return n
+			case float64:
+				return int(n)
+			}
+
+			// not given, e.g. query($l: Int = 100) without $l
+			value = a.defaults[v.Name.Value]
+		}
+
+		if v, ok := value.(*ast.IntValue); ok {
+			n, err := strconv.Atoi(v.Value)
+			if err == nil {
+				return n
+			}
+		}
+	}
+
+	for _, arg := range def.Args {
+		if arg.Name() == "limit" {
+			return defaultListLimit
+		}
+	}
+
+	return listSize
+}
+
+// addCost adds costs, stopping at maxCost
+func addCost(a, b int) int {
+	if a > maxCost-b {
+		return maxCost
+	}
+	return a + b
+}
+
+// unwrapType returns the object type a field resolves to, if any, and
+// whether it is a list
+func unwrapType(t graphql.Type) (*graphql.Object, bool) {
+	isList := false
+	for {
+		switch typ := t.(type) {
+		case *graphql.NonNull:
+			t = typ.OfType
+		case *graphql.List:
+			isList = true
+			t = typ.OfType
+		case *graphql.Object:
+			return typ, isList
+		default:
+			return nil, isList
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package graph
+
+import (
+	"backend/internal/repository/dbrepo"
+	"fmt"
+	"testing"
+)
+
+// complexity returns the complexity checkLimits measures for a request
+func complexity(t *testing.T, g *Graph, req Request) int {
+	t.Helper()
+
+	g.Limits = Limits{MaxComplexity: 1}
+	errs := g.checkLimits(req)
+	if len(errs) != 1 {
+		t.Fatalf("checkLimits(%q) = %v, want one error", req.Query, errs)
+	}
+
+	var n, limit int
+	_, err := fmt.Sscanf(errs[0].Message, "query has a complexity of %d, the limit is %d", &n, &limit)
+	if err != nil {
+		t.Fatalf("unexpected error %q", errs[0].Message)
+	}
+
+	return n
+}
+
+func TestListSizeFromVariables(t *testing.T) {
+	g, err := New(dbrepo.NewMemoryDbRepo())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selection := `{ id genres { id } }`
+	literal := complexity(t, g, Request{Query: `{ list(limit: 100) ` + selection + ` }`})
+	small := complexity(t, g, Request{Query: `{ list(limit: 20) ` + selection + ` }`})
+	if literal <= small {
+		t.Fatalf("limit 100 costs %d, no more than limit 20 at %d", literal, small)
+	}
+
+	tests := []struct {
+		name string
+		req  Request
+		want int
+	}{
+		{
+			name: "given",
+			req: Request{
+				Query:     `query($l: Int) { list(limit: $l) ` + selection + ` }`,
+				Variables: map[string]any{"l": float64(100)},
+			},
+			want: literal,
+		},
+		{
+			name: "default",
+			req:  Request{Query: `query($l: Int = 100) { list(limit: $l) ` + selection + ` }`},
+			want: literal,
+		},
+		{
+			name: "given over default",
+			req: Request{
+				Query:     `query($l: Int = 100) { list(limit: $l) ` + selection + ` }`,
+				Variables: map[string]any{"l": float64(20)},
+			},
+			want: small,
+		},
+		{
+			name: "no default",
+			req:  Request{Query: `query($l: Int) { list(limit: $l) ` + selection + ` }`},
+			want: small,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := complexity(t, g, tt.req); got != tt.want {
+				t.Errorf("complexity = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
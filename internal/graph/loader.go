@@ -0,0 +1,111 @@
+package graph
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// loader batches lookups by id. Resolvers call load, which returns a thunk;
+// graphql-go resolves every field at one level of the query before running
+// the thunks, so the first thunk to run fetches all the ids queued so far
+// in a single call.
+type loader[V any] struct {
+	mu      sync.Mutex
+	fetch   func(ids []int) (map[int]V, error)
+	pending []int
+	queued  map[int]bool
+	results map[int]V
+	errs    map[int]error
+}
+
+func newLoader[V any](fetch func(ids []int) (map[int]V, error)) *loader[V] {
+	return &loader[V]{
+		fetch:   fetch,
+		queued:  make(map[int]bool),
+		results: make(map[int]V),
+		errs:    make(map[int]error),
+	}
+}
+
+func (l *loader[V]) load(id int) func() (any, error) {
+	l.mu.Lock()
+	if !l.queued[id] {
+		l.queued[id] = true
+		l.pending = append(l.pending, id)
+	}
+	l.mu.Unlock()
+
+	return func() (any, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if len(l.pending) > 0 {
+			batch := l.pending
+			l.pending = nil
+
+			results, err := l.fetch(batch)
+			for _, id := range batch {
+				if err != nil {
+					l.errs[id] = err
+					continue
+				}
+				l.results[id] = results[id]
+			}
+		}
+
+		return l.results[id], l.errs[id]
+	}
+}
+
+// loaders holds the loaders of one request, so results are never shared
+// between users or served stale
+type loaders struct {
+	mu sync.Mutex
+	db repository.DatabaseRepo
+
+	movieGenres *loader[[]*models.Genre]
+
+	// genre movies are loaded in pages, with a loader per page
+	genreMovies map[string]*loader[[]*models.Movie]
+}
+
+func newLoaders(db repository.DatabaseRepo) *loaders {
+	return &loaders{
+		db:          db,
+		movieGenres: newLoader(db.GenresForMovies),
+		genreMovies: make(map[string]*loader[[]*models.Movie]),
+	}
+}
+
+func (l *loaders) genreMoviesPage(page, limit int) *loader[[]*models.Movie] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := fmt.Sprintf("%d:%d", page, limit)
+	if _, ok := l.genreMovies[key]; !ok {
+		l.genreMovies[key] = newLoader(func(ids []int) (map[int][]*models.Movie, error) {
+			return l.db.MoviesForGenres(ids, page, limit)
+		})
+	}
+
+	return l.genreMovies[key]
+}
+
+const loadersContextKey contextKey = "loaders"
+
+func withLoaders(ctx context.Context, l *loaders) context.Context {
+	return context.WithValue(ctx, loadersContextKey, l)
+}
+
+// loadersFrom returns the loaders of the request, or fresh ones for a
+// context that has none
+func (g *Graph) loadersFrom(ctx context.Context) *loaders {
+	if l, ok := ctx.Value(loadersContextKey).(*loaders); ok {
+		return l
+	}
+
+	return newLoaders(g.db(ctx))
+}
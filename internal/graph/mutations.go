@@ -0,0 +1,421 @@
+package graph
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+// fieldErrorType is a validation error for one field of the input
+var fieldErrorType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "FieldError",
+		Fields: graphql.Fields{
+			"field": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"message": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+		},
+	},
+)
+
+// movieInputType is what editors can set on a movie
+var movieInputType = graphql.NewInputObject(
+	graphql.InputObjectConfig{
+		Name: "MovieInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"description": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"release_date": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "YYYY-MM-DD",
+			},
+			"runtime": &graphql.InputObjectFieldConfig{
+				Type: graphql.Int,
+			},
+			"mpaa_rating": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"genres_array": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewList(graphql.NewNonNull(graphql.Int)),
+				Description: "Genre ids, replacing the current genres when given",
+			},
+		},
+	},
+)
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// moviePayload is the result of a mutation on a movie. Either Movie is set,
+// or Errors says what was wrong with the input.
+type moviePayload struct {
+	Movie  *models.Movie `json:"movie"`
+	Errors []fieldError  `json:"errors"`
+}
+
+type deleteMoviePayload struct {
+	DeletedId *int         `json:"deleted_id"`
+	Errors    []fieldError `json:"errors"`
+}
+
+// newMutationFields returns the actions that change movies. Every mutation
+// needs an editor, except deleting which needs an admin, as on /admin.
+func (g *Graph) newMutationFields(movieType *graphql.Object) graphql.Fields {
+	errorsField := &graphql.Field{
+		Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(fieldErrorType))),
+	}
+
+	moviePayloadType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "MoviePayload",
+			Fields: graphql.Fields{
+				"movie": &graphql.Field{
+					Type: movieType,
+				},
+				"errors": errorsField,
+			},
+		},
+	)
+
+	deleteMoviePayloadType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "DeleteMoviePayload",
+			Fields: graphql.Fields{
+				"deleted_id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"errors": errorsField,
+			},
+		},
+	)
+
+	idArg := &graphql.ArgumentConfig{
+		Type: graphql.NewNonNull(graphql.Int),
+	}
+	versionArg := &graphql.ArgumentConfig{
+		Type:        graphql.NewNonNull(graphql.Int),
+		Description: "The version the movie was read at; the mutation fails if it has changed since",
+	}
+
+	return graphql.Fields{
+		"createMovie": &graphql.Field{
+			Type:        moviePayloadType,
+			Description: "Add a movie",
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(movieInputType),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				err := requireRole(params.Context, models.RoleEditor)
+				if err != nil {
+					return nil, err
+				}
+
+				input, _ := params.Args["input"].(map[string]any)
+
+				var movie models.Movie
+				v := validator.New()
+				genreIds, hasGenres := applyMovieInput(v, &movie, input)
+				if hasGenres {
+					g.checkGenres(params.Context, v, "genres_array", genreIds)
+				}
+				if !v.Valid() {
+					return invalid(v), nil
+				}
+
+				movie.CreatedAt = time.Now()
+				movie.UpdatedAt = time.Now()
+
+				if g.FindPoster != nil {
+					movie = g.FindPoster(params.Context, movie)
+				}
+
+				var newId int
+				err = g.db(params.Context).WithTx(func(repo repository.DatabaseRepo) error {
+					var err error
+					newId, err = repo.InsertMovie(movie)
+					if err != nil || !hasGenres {
+						return err
+					}
+
+					return repo.UpdateMovieGenres(newId, genreIds)
+				})
+				if err != nil {
+					return nil, err
+				}
+
+				return g.moviePayload(params.Context, newId)
+			},
+		},
+
+		"updateMovie": &graphql.Field{
+			Type:        moviePayloadType,
+			Description: "Change a movie",
+			Args: graphql.FieldConfigArgument{
+				"id":      idArg,
+				"version": versionArg,
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(movieInputType),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				err := requireRole(params.Context, models.RoleEditor)
+				if err != nil {
+					return nil, err
+				}
+
+				id, _ := params.Args["id"].(int)
+				movie, err := g.db(params.Context).OneMovie(id)
+				if errors.Is(err, sql.ErrNoRows) {
+					return notFound("id"), nil
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				movie.Version, _ = params.Args["version"].(int)
+
+				input, _ := params.Args["input"].(map[string]any)
+
+				v := validator.New()
+				genreIds, hasGenres := applyMovieInput(v, movie, input)
+				if hasGenres {
+					g.checkGenres(params.Context, v, "genres_array", genreIds)
+				}
+				if !v.Valid() {
+					return invalid(v), nil
+				}
+
+				movie.UpdatedAt = time.Now()
+
+				err = g.db(params.Context).WithTx(func(repo repository.DatabaseRepo) error {
+					err := repo.UpdateMovie(*movie)
+					if err != nil || !hasGenres {
+						return err
+					}
+
+					return repo.UpdateMovieGenres(id, genreIds)
+				})
+				if errors.Is(err, models.ErrEditConflict) {
+					return editConflict(), nil
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				return g.moviePayload(params.Context, id)
+			},
+		},
+
+		"deleteMovie": &graphql.Field{
+			Type:        deleteMoviePayloadType,
+			Description: "Delete a movie",
+			Args: graphql.FieldConfigArgument{
+				"id":      idArg,
+				"version": versionArg,
+			},
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				err := requireRole(params.Context, models.RoleAdmin)
+				if err != nil {
+					return nil, err
+				}
+
+				id, _ := params.Args["id"].(int)
+				movie, err := g.db(params.Context).OneMovie(id)
+				if errors.Is(err, sql.ErrNoRows) {
+					return deleteMoviePayload{Errors: []fieldError{{Field: "id", Message: "movie not found"}}}, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				movie.Version, _ = params.Args["version"].(int)
+
+				err = g.db(params.Context).DeleteMovie(id, movie.Version)
+				if errors.Is(err, models.ErrEditConflict) {
+					return deleteMoviePayload{Errors: editConflict().Errors}, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				return deleteMoviePayload{DeletedId: &id, Errors: []fieldError{}}, nil
+			},
+		},
+
+		"setMovieGenres": &graphql.Field{
+			Type:        moviePayloadType,
+			Description: "Replace the genres of a movie",
+			Args: graphql.FieldConfigArgument{
+				"id":      idArg,
+				"version": versionArg,
+				"genreIds": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int))),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (any, error) {
+				err := requireRole(params.Context, models.RoleEditor)
+				if err != nil {
+					return nil, err
+				}
+
+				id, _ := params.Args["id"].(int)
+				movie, err := g.db(params.Context).OneMovie(id)
+				if errors.Is(err, sql.ErrNoRows) {
+					return notFound("id"), nil
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				movie.Version, _ = params.Args["version"].(int)
+
+				genreIds := toInts(params.Args["genreIds"])
+
+				v := validator.New()
+				g.checkGenres(params.Context, v, "genreIds", genreIds)
+				if !v.Valid() {
+					return invalid(v), nil
+				}
+
+				movie.UpdatedAt = time.Now()
+
+				// writing the movie at the version given checks it, as
+				// updateMovie does, before the genres are replaced
+				err = g.db(params.Context).WithTx(func(repo repository.DatabaseRepo) error {
+					err := repo.UpdateMovie(*movie)
+					if err != nil {
+						return err
+					}
+
+					return repo.UpdateMovieGenres(id, genreIds)
+				})
+				if errors.Is(err, models.ErrEditConflict) {
+					return editConflict(), nil
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				return g.moviePayload(params.Context, id)
+			},
+		},
+	}
+}
+
+// applyMovieInput copies the input onto a movie and validates the result.
+// It also returns the genre ids, if they were given.
+func applyMovieInput(v *validator.Validator, movie *models.Movie, input map[string]any) ([]int, bool) {
+	if title, ok := input["title"].(string); ok {
+		movie.Title = title
+	}
+	if description, ok := input["description"].(string); ok {
+		movie.Description = description
+	}
+	if runtime, ok := input["runtime"].(int); ok {
+		movie.RunTime = runtime
+	}
+	if rating, ok := input["mpaa_rating"].(string); ok {
+		movie.MpaaRating = rating
+	}
+	if date, ok := input["release_date"].(string); ok {
+		releaseDate, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			v.AddError("release_date", "must be a date in YYYY-MM-DD format")
+		} else {
+			movie.ReleaseDate = releaseDate
+		}
+	}
+
+	models.ValidateMovie(v, movie)
+
+	genres, ok := input["genres_array"]
+	if !ok || genres == nil {
+		return nil, false
+	}
+
+	return toInts(genres), true
+}
+
+// checkGenres adds an error for field unless every genre id exists
+func (g *Graph) checkGenres(ctx context.Context, v *validator.Validator, field string, genreIds []int) {
+	genres, err := g.db(ctx).AllGenres()
+	if err != nil {
+		v.AddError(field, err.Error())
+		return
+	}
+
+	known := make(map[int]bool)
+	for _, genre := range genres {
+		known[genre.Id] = true
+	}
+
+	for _, id := range genreIds {
+		if !known[id] {
+			v.AddError(field, "contains an unknown genre")
+			return
+		}
+	}
+}
+
+// moviePayload returns the movie as stored, after a successful mutation
+func (g *Graph) moviePayload(ctx context.Context, id int) (any, error) {
+	movie, err := g.db(ctx).OneMovie(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return moviePayload{Movie: movie, Errors: []fieldError{}}, nil
+}
+
+func invalid(v *validator.Validator) moviePayload {
+	var errs []fieldError
+	for field, message := range v.Errors {
+		errs = append(errs, fieldError{Field: field, Message: message})
+	}
+
+	// maps are unordered; keep responses stable
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+
+	return moviePayload{Errors: errs}
+}
+
+func notFound(field string) moviePayload {
+	return moviePayload{Errors: []fieldError{{Field: field, Message: "movie not found"}}}
+}
+
+func editConflict() moviePayload {
+	return moviePayload{Errors: []fieldError{{Field: "version", Message: models.ErrEditConflict.Error()}}}
+}
+
+func toInts(value any) []int {
+	list, _ := value.([]any)
+
+	ints := []int{}
+	for _, item := range list {
+		if n, ok := item.(int); ok {
+			ints = append(ints, n)
+		}
+	}
+
+	return ints
+}
@@ -0,0 +1,221 @@
+package graph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Error codes of the persisted query protocol, as Apollo clients expect them
+const (
+	CodePersistedQueryNotFound     = "PERSISTED_QUERY_NOT_FOUND"
+	CodePersistedQueryNotSupported = "PERSISTED_QUERY_NOT_SUPPORTED"
+	CodePersistedQueryNotAllowed   = "PERSISTED_QUERY_NOT_ALLOWED"
+	CodePersistedQueryInvalid      = "PERSISTED_QUERY_INVALID"
+)
+
+// maxPersistedQueries bounds the queries clients can register, as anyone
+// can register one
+const maxPersistedQueries = 1000
+
+// maxPersistedQueryBytes bounds the length of a query clients can register.
+// Longer ones still run, but have to be sent in full every time.
+const maxPersistedQueryBytes = 16 * 1024
+
+// PersistedQueryError is a failure of the persisted query protocol. Its code
+// is reported in the extensions of the GraphQL error.
+type PersistedQueryError struct {
+	Code    string
+	Message string
+}
+
+func (e *PersistedQueryError) Error() string {
+	return e.Message
+}
+
+func (e *PersistedQueryError) Extensions() map[string]any {
+	return map[string]any{"code": e.Code}
+}
+
+// PersistedQueries maps sha256 hashes to queries. Clients send the hash of
+// a query instead of the query; when the hash is not known they retry with
+// both, which registers the query once it has run.
+//
+// In allowlist mode only the queries of the manifest run, whether sent by
+// hash or in full, and nothing can be registered.
+type PersistedQueries struct {
+	AllowlistOnly bool
+
+	mu      sync.RWMutex
+	queries map[string]string
+	// manifest holds the hashes loaded at startup, which are never evicted
+	manifest map[string]bool
+}
+
+func NewPersistedQueries() *PersistedQueries {
+	return &PersistedQueries{
+		queries:  make(map[string]string),
+		manifest: make(map[string]bool),
+	}
+}
+
+// manifest is the persisted query manifest generated by Apollo tooling
+type manifest struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	Operations []struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Body string `json:"body"`
+	} `json:"operations"`
+}
+
+// LoadManifest adds the operations of a manifest file. Each id must be the
+// sha256 hash of its body.
+func (p *PersistedQueries) LoadManifest(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var m manifest
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if m.Format != "apollo-persisted-query-manifest" || m.Version != 1 {
+		return fmt.Errorf("reading %s: unsupported manifest format %q version %d", path, m.Format, m.Version)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, op := range m.Operations {
+		if hashQuery(op.Body) != op.Id {
+			return fmt.Errorf("reading %s: id of operation %s does not match its body", path, op.Name)
+		}
+
+		p.queries[op.Id] = op.Body
+		p.manifest[op.Id] = true
+	}
+
+	return nil
+}
+
+// Resolve fills in the query of a request sent by hash, checks the hash of
+// a request sent with both, and enforces the allowlist. Call Register once
+// a request sent with both has run.
+func (p *PersistedQueries) Resolve(req Request) (Request, error) {
+	hash, err := req.persistedHash()
+	if err != nil {
+		return req, err
+	}
+
+	if hash == "" {
+		if p.AllowlistOnly && !p.allowed(hashQuery(req.Query)) {
+			return req, &PersistedQueryError{CodePersistedQueryNotAllowed, "only persisted queries may be run"}
+		}
+		return req, nil
+	}
+
+	// Sent by hash
+	if req.Query == "" {
+		p.mu.RLock()
+		query, ok := p.queries[hash]
+		p.mu.RUnlock()
+
+		if !ok {
+			if p.AllowlistOnly {
+				return req, &PersistedQueryError{CodePersistedQueryNotAllowed, "only persisted queries may be run"}
+			}
+			return req, &PersistedQueryError{CodePersistedQueryNotFound, "PersistedQueryNotFound"}
+		}
+
+		req.Query = query
+		return req, nil
+	}
+
+	// Sent with the query, to register it
+	if hashQuery(req.Query) != hash {
+		return req, &PersistedQueryError{CodePersistedQueryInvalid, "provided sha256Hash does not match query"}
+	}
+
+	if p.AllowlistOnly && !p.allowed(hash) {
+		return req, &PersistedQueryError{CodePersistedQueryNotAllowed, "only persisted queries may be run"}
+	}
+
+	return req, nil
+}
+
+// Register stores the query of a request sent with both its hash and the
+// query. Only register requests that ran, so that queries which don't parse
+// or are over the limits never take up room.
+func (p *PersistedQueries) Register(req Request) {
+	if p.AllowlistOnly || len(req.Query) > maxPersistedQueryBytes {
+		return
+	}
+
+	hash, err := req.persistedHash()
+	if err != nil || hash == "" || hashQuery(req.Query) != hash {
+		return
+	}
+
+	p.register(hash, req.Query)
+}
+
+func (p *PersistedQueries) allowed(hash string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.manifest[hash]
+}
+
+func (p *PersistedQueries) register(hash, query string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.queries[hash]; ok {
+		return
+	}
+
+	// Make room by dropping any registered query; they are cheap to register again
+	if len(p.queries) >= maxPersistedQueries+len(p.manifest) {
+		for h := range p.queries {
+			if !p.manifest[h] {
+				delete(p.queries, h)
+				break
+			}
+		}
+	}
+
+	p.queries[hash] = query
+}
+
+func hashQuery(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// persistedHash returns the hash of the persistedQuery extension, if the
+// request has one
+func (req Request) persistedHash() (string, error) {
+	ext, ok := req.Extensions["persistedQuery"].(map[string]any)
+	if !ok {
+		return "", nil
+	}
+
+	if version, _ := ext["version"].(float64); version != 1 {
+		return "", &PersistedQueryError{CodePersistedQueryNotSupported, "unsupported persisted query version"}
+	}
+
+	hash, _ := ext["sha256Hash"].(string)
+	if hash == "" {
+		return "", &PersistedQueryError{CodePersistedQueryInvalid, "persisted query has no sha256Hash"}
+	}
+
+	return hash, nil
+}
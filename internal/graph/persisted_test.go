@@ -0,0 +1,81 @@
+package graph
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// persisted is a request sent the way Apollo clients send one: by hash
+// alone, or with the query too
+func persisted(hash, query string) Request {
+	return Request{
+		Query: query,
+		Extensions: map[string]any{
+			"persistedQuery": map[string]any{"version": float64(1), "sha256Hash": hash},
+		},
+	}
+}
+
+// assertCode checks the persisted query error code of err
+func assertCode(t *testing.T, err error, code string) {
+	t.Helper()
+
+	var pqErr *PersistedQueryError
+	if !errors.As(err, &pqErr) || pqErr.Code != code {
+		t.Errorf("got %v, want %s", err, code)
+	}
+}
+
+func TestRegisterAfterRun(t *testing.T) {
+	p := NewPersistedQueries()
+	query := `{ genres { id } }`
+	hash := hashQuery(query)
+
+	// sending the query along only checks it; the handler registers it
+	// once it has run
+	_, err := p.Resolve(persisted(hash, query))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Resolve(persisted(hash, ""))
+	assertCode(t, err, CodePersistedQueryNotFound)
+
+	p.Register(persisted(hash, query))
+
+	req, err := p.Resolve(persisted(hash, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Query != query {
+		t.Errorf("resolved query %q, want %q", req.Query, query)
+	}
+}
+
+func TestRegisterRefuses(t *testing.T) {
+	long := `{ genres { id ` + strings.Repeat("genre ", maxPersistedQueryBytes/6) + `} }`
+
+	tests := []struct {
+		name      string
+		req       Request
+		allowlist bool
+	}{
+		{"too long", persisted(hashQuery(long), long), false},
+		{"wrong hash", persisted(hashQuery(`{ people { id } }`), `{ genres { id } }`), false},
+		{"no hash", Request{Query: `{ genres { id } }`}, false},
+		{"allowlist", persisted(hashQuery(`{ genres { id } }`), `{ genres { id } }`), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPersistedQueries()
+			p.AllowlistOnly = tt.allowlist
+
+			p.Register(tt.req)
+
+			if len(p.queries) != 0 {
+				t.Errorf("registered %d queries, want none", len(p.queries))
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+var ErrMissingQuery = errors.New("query is required")
+
+// Request is a GraphQL request, as sent over HTTP
+type Request struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+	Extensions    map[string]any `json:"extensions"`
+}
+
+// Empty reports whether the request has neither a query nor the hash of
+// a persisted one
+func (req Request) Empty() bool {
+	return req.Query == "" && req.Extensions["persistedQuery"] == nil
+}
+
+// RequestFromQuery reads a request from the parameters of a GET request.
+// Variables and extensions are JSON encoded objects.
+func RequestFromQuery(values url.Values) (Request, error) {
+	req := Request{
+		Query:         values.Get("query"),
+		OperationName: values.Get("operationName"),
+	}
+
+	if v := values.Get("variables"); v != "" {
+		err := json.Unmarshal([]byte(v), &req.Variables)
+		if err != nil {
+			return req, fmt.Errorf("variables must be a JSON object: %v", err)
+		}
+	}
+
+	if v := values.Get("extensions"); v != "" {
+		err := json.Unmarshal([]byte(v), &req.Extensions)
+		if err != nil {
+			return req, fmt.Errorf("extensions must be a JSON object: %v", err)
+		}
+	}
+
+	if req.Empty() {
+		return req, ErrMissingQuery
+	}
+
+	return req, nil
+}
+
+// Operation returns the type of the operation the request would run:
+// "query", "mutation" or "subscription". Documents that do not parse, or
+// do not name a single operation, are reported as queries; executing them
+// reports the error.
+func (req Request) Operation() string {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(req.Query)}),
+	})
+	if err != nil {
+		return ast.OperationTypeQuery
+	}
+
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		if req.OperationName == "" {
+			if found != nil {
+				return ast.OperationTypeQuery
+			}
+			found = op
+			continue
+		}
+
+		if op.Name != nil && op.Name.Value == req.OperationName {
+			found = op
+			break
+		}
+	}
+
+	if found == nil {
+		return ast.OperationTypeQuery
+	}
+
+	return found.Operation
+}
@@ -0,0 +1,41 @@
+package graph
+
+import (
+	"backend/internal/models"
+	"context"
+	"errors"
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+)
+
+// Viewer is the authenticated user making a request
+type Viewer struct {
+	UserId int
+	Role   string
+}
+
+type contextKey string
+
+const viewerContextKey contextKey = "viewer"
+
+// WithViewer returns a context carrying the authenticated user
+func WithViewer(ctx context.Context, viewer *Viewer) context.Context {
+	return context.WithValue(ctx, viewerContextKey, viewer)
+}
+
+// requireRole checks the request was made by a user with at least role
+func requireRole(ctx context.Context, role string) error {
+	viewer, ok := ctx.Value(viewerContextKey).(*Viewer)
+	if !ok || viewer == nil {
+		return ErrUnauthorized
+	}
+
+	if !models.RoleAllows(viewer.Role, role) {
+		return ErrForbidden
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry. The values match those of log/slog,
+// so entries compare the same way once the module can use it.
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// ParseLevel reads a level by name, in any case
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "DEBUG":
+		return LevelDebug, nil
+	case "INFO":
+		return LevelInfo, nil
+	case "WARN":
+		return LevelWarn, nil
+	case "ERROR":
+		return LevelError, nil
+	}
+
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger writes entries as JSON objects, one per line, in the shape of the
+// log/slog JSON handler:
+//
+//	{"time":"...","level":"INFO","msg":"...","key":"value"}
+//
+// Attributes are given as alternating keys and values, as with slog. A
+// Logger is safe for concurrent use.
+type Logger struct {
+	mu    *sync.Mutex
+	out   io.Writer
+	level Level
+
+	// attrs are the encoded attributes added by With, each starting with a
+	// comma
+	attrs []byte
+}
+
+// New returns a Logger that writes entries of at least level to out
+func New(out io.Writer, level Level) *Logger {
+	return &Logger{mu: &sync.Mutex{}, out: out, level: level}
+}
+
+// With returns a Logger that adds the given attributes to every entry
+func (l *Logger) With(args ...any) *Logger {
+	var buf bytes.Buffer
+	buf.Write(l.attrs)
+	appendAttrs(&buf, args)
+
+	c := *l
+	c.attrs = buf.Bytes()
+	return &c
+}
+
+// Enabled reports whether entries of level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debug(msg string, args ...any) {
+	l.Log(LevelDebug, msg, args...)
+}
+
+func (l *Logger) Info(msg string, args ...any) {
+	l.Log(LevelInfo, msg, args...)
+}
+
+func (l *Logger) Warn(msg string, args ...any) {
+	l.Log(LevelWarn, msg, args...)
+}
+
+func (l *Logger) Error(msg string, args ...any) {
+	l.Log(LevelError, msg, args...)
+}
+
+// Log writes an entry, if its level is enabled
+func (l *Logger) Log(level Level, msg string, args ...any) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	appendValue(&buf, time.Now().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	appendValue(&buf, level.String())
+	buf.WriteString(`,"msg":`)
+	appendValue(&buf, msg)
+	buf.Write(l.attrs)
+	appendAttrs(&buf, args)
+	buf.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, _ = l.out.Write(buf.Bytes())
+}
+
+// StdLogger returns a *log.Logger whose lines are written as entries of
+// level, for code that logs through the standard library
+func (l *Logger) StdLogger(level Level) *log.Logger {
+	return log.New(lineWriter{l, level}, "", 0)
+}
+
+// lineWriter turns each write of a standard logger into an entry
+type lineWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w.logger.Log(w.level, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// appendAttrs encodes key value pairs. A key without a value is logged
+// under !BADKEY, as slog does.
+func appendAttrs(buf *bytes.Buffer, args []any) {
+	for len(args) > 0 {
+		key, ok := args[0].(string)
+		if !ok || len(args) == 1 {
+			key = "!BADKEY"
+			buf.WriteByte(',')
+			appendValue(buf, key)
+			buf.WriteByte(':')
+			appendValue(buf, args[0])
+			args = args[1:]
+			continue
+		}
+
+		buf.WriteByte(',')
+		appendValue(buf, key)
+		buf.WriteByte(':')
+		appendValue(buf, args[1])
+		args = args[2:]
+	}
+}
+
+// appendValue encodes a value as JSON. Errors are logged by their message
+// and durations in nanoseconds, as slog does; values that cannot be encoded
+// are logged as formatted by fmt.
+func appendValue(buf *bytes.Buffer, value any) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = int64(v)
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(b)
+}
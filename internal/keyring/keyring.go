@@ -0,0 +1,559 @@
+package keyring
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Supported signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Key is one signing key. For HS256 the private and public keys are both the
+// shared secret.
+type Key struct {
+	Id        string
+	Algorithm string
+	Private   any
+	Public    any
+	CreatedAt time.Time
+}
+
+// Keyring holds the current signing key plus recently retired keys, which
+// stay valid for verification until Retention has passed since they were
+// replaced. Asymmetric keys can be persisted as PEM files in a directory, so
+// every instance of the API shares them: the directory is the source of
+// truth, and each instance reads it again on a timer and whenever a token
+// names a key it does not know. Rotating and pruning take a lock file in the
+// directory, so only one instance at a time writes or deletes keys.
+type Keyring struct {
+	mu sync.RWMutex
+
+	algorithm string
+	dir       string
+
+	// RotateEvery is the age at which AutoRotate replaces the current key.
+	// Zero disables rotation.
+	RotateEvery time.Duration
+
+	// Retention is how long a replaced key can still verify tokens. It
+	// should be at least the lifetime of the longest lived token.
+	Retention time.Duration
+
+	// keys are sorted newest first; keys[0] is the current key
+	keys []*Key
+
+	// reloadedAt is when an unknown kid last made the key directory be read
+	reloadedAt time.Time
+}
+
+const (
+	// refreshEvery is how often AutoRotate reads the key directory, to pick
+	// up keys rotated by other instances
+	refreshEvery = time.Minute
+
+	// reloadGap limits how often an unknown kid makes the directory be read
+	// again, so that tokens with made up kids can't keep the disk busy
+	reloadGap = 5 * time.Second
+
+	// lockWait is how long rotation waits for another instance's lock, and
+	// lockStale the age at which a lock is taken to be left over by an
+	// instance that crashed while rotating
+	lockWait  = 10 * time.Second
+	lockStale = time.Minute
+
+	lockFile = "rotate.lock"
+)
+
+// NewHMAC returns a keyring with a single shared secret, which can't be
+// rotated or published
+func NewHMAC(secret string) *Keyring {
+	return &Keyring{
+		algorithm: HS256,
+		keys: []*Key{{
+			Id:        "default",
+			Algorithm: HS256,
+			Private:   []byte(secret),
+			Public:    []byte(secret),
+			CreatedAt: time.Now(),
+		}},
+	}
+}
+
+// New returns a keyring of RS256 or ES256 keys. Keys are loaded from dir, if
+// given, and a key is generated when there are none.
+func New(algorithm, dir string, rotateEvery, retention time.Duration) (*Keyring, error) {
+	if algorithm != RS256 && algorithm != ES256 {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	k := &Keyring{
+		algorithm:   algorithm,
+		dir:         dir,
+		RotateEvery: rotateEvery,
+		Retention:   retention,
+	}
+
+	if dir != "" {
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// only generates a key if the directory has none, even when several
+	// instances start at once
+	err := k.rotate(func(current *Key) bool { return false })
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Algorithm returns the signing algorithm of the keyring
+func (k *Keyring) Algorithm() string {
+	return k.algorithm
+}
+
+// Current returns the key new tokens are signed with
+func (k *Keyring) Current() *Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.keys[0]
+}
+
+// Keys returns every key that can verify tokens, newest first
+func (k *Keyring) Keys() []*Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return append([]*Key(nil), k.keys...)
+}
+
+// Lookup finds a key that can verify tokens by its id. An unknown id may
+// be a key that another instance has just rotated in, so the key directory
+// is read again before giving up.
+func (k *Keyring) Lookup(id string) (*Key, error) {
+	key := k.find(id)
+	if key == nil && k.dir != "" && k.mayReload() {
+		err := k.reload()
+		if err != nil {
+			log.Println("reading signing keys failed:", err)
+		}
+		key = k.find(id)
+	}
+
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+func (k *Keyring) find(id string) *Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.Id == id {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// mayReload reports whether an unknown kid may make the key directory be
+// read again, and if so takes the turn
+func (k *Keyring) mayReload() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Since(k.reloadedAt) < reloadGap {
+		return false
+	}
+	k.reloadedAt = time.Now()
+
+	return true
+}
+
+// Sign signs a token with the current key, setting its kid header
+func (k *Keyring) Sign(token *jwt.Token) (string, error) {
+	key := k.Current()
+
+	token.Method = jwt.GetSigningMethod(key.Algorithm)
+	token.Header["alg"] = key.Algorithm
+	token.Header["kid"] = key.Id
+
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc is a jwt.Keyfunc that picks the verification key by kid, and
+// rejects tokens signed with any other algorithm than the key's
+func (k *Keyring) Keyfunc(token *jwt.Token) (any, error) {
+	// tokens from before keys had ids were signed with the shared secret
+	id, _ := token.Header["kid"].(string)
+	if id == "" && k.algorithm == HS256 {
+		id = "default"
+	}
+
+	key, err := k.Lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// validate signing algorithm. Miss this and it's a vulnerability
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.Public, nil
+}
+
+// Rotate makes a new current key, and drops keys that were replaced more
+// than Retention ago
+func (k *Keyring) Rotate() error {
+	return k.rotate(func(current *Key) bool { return true })
+}
+
+// rotate makes a new current key if there is none, or if due reports that
+// the current one needs replacing. With a key directory, the decision is
+// taken on the keys in the directory, under the rotation lock, so that
+// instances rotating at the same time make one new key between them.
+func (k *Keyring) rotate(due func(current *Key) bool) error {
+	if k.algorithm == HS256 {
+		return errors.New("shared secrets can't be rotated")
+	}
+
+	if k.dir == "" {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		if len(k.keys) > 0 && !due(k.keys[0]) {
+			return nil
+		}
+
+		key, err := generateKey(k.algorithm)
+		if err != nil {
+			return err
+		}
+		k.keys = k.expire(append([]*Key{key}, k.keys...), false)
+
+		return nil
+	}
+
+	unlock, err := k.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := k.read()
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 || due(keys[0]) {
+		key, err := generateKey(k.algorithm)
+		if err != nil {
+			return err
+		}
+
+		err = k.save(key)
+		if err != nil {
+			return err
+		}
+		keys = append([]*Key{key}, keys...)
+	}
+
+	// only the holder of the lock deletes key files
+	k.setKeys(k.expire(keys, true))
+
+	return nil
+}
+
+// AutoRotate rotates the current key whenever it is older than RotateEvery,
+// and reads the key directory every minute for keys that other instances
+// rotated in, until ctx is done
+func (k *Keyring) AutoRotate(ctx context.Context) {
+	if k.algorithm == HS256 || (k.RotateEvery <= 0 && k.dir == "") {
+		return
+	}
+
+	// check often enough to rotate close to on time
+	interval := refreshEvery
+	if k.RotateEvery > 0 && k.RotateEvery/10 < interval {
+		interval = k.RotateEvery / 10
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if k.dir != "" {
+			err := k.reload()
+			if err != nil {
+				log.Println("reading signing keys failed:", err)
+			}
+		}
+
+		if k.RotateEvery > 0 && time.Since(k.Current().CreatedAt) >= k.RotateEvery {
+			// another instance may have rotated since the keys were read,
+			// so the age is checked again under the lock
+			err := k.rotate(func(current *Key) bool {
+				return time.Since(current.CreatedAt) >= k.RotateEvery
+			})
+			if err != nil {
+				log.Println("key rotation failed:", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expire drops the keys, sorted newest first, that were replaced more than
+// Retention ago. A key is replaced when the key after it was created. With
+// remove set, the files of dropped keys are deleted too.
+func (k *Keyring) expire(keys []*Key, remove bool) []*Key {
+	if len(keys) == 0 {
+		return keys
+	}
+
+	kept := keys[:1]
+	for i := 1; i < len(keys); i++ {
+		replacedAt := keys[i-1].CreatedAt
+		if time.Since(replacedAt) > k.Retention {
+			if remove && k.dir != "" {
+				_ = os.Remove(k.keyPath(keys[i].Id))
+			}
+			continue
+		}
+		kept = append(kept, keys[i])
+	}
+
+	return kept
+}
+
+// setKeys replaces the keys with those read from the key directory
+func (k *Keyring) setKeys(keys []*Key) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// keep signing with what we have rather than with nothing, should the
+	// directory be emptied from under us
+	if len(keys) > 0 {
+		k.keys = keys
+	}
+}
+
+func (k *Keyring) keyPath(id string) string {
+	return filepath.Join(k.dir, id+".pem")
+}
+
+// reload reads the key directory again, dropping expired keys without
+// deleting their files
+func (k *Keyring) reload() error {
+	keys, err := k.read()
+	if err != nil {
+		return err
+	}
+
+	k.setKeys(k.expire(keys, false))
+
+	return nil
+}
+
+// read returns the keys of every PKCS #8 PEM file in the key directory,
+// newest first. A key's age is the modification time of its file.
+func (k *Keyring) read() ([]*Key, error) {
+	paths, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*Key
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// pruned by another instance since the glob
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: not a PEM file", path)
+		}
+
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := newKey(private, info.ModTime())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		// keys of another algorithm are left alone, and not trusted
+		if key.Algorithm != k.algorithm {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+func (k *Keyring) save(key *Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	// write then rename, so other instances never read half a key
+	tmp := k.keyPath(key.Id) + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, k.keyPath(key.Id))
+	if err != nil {
+		return err
+	}
+
+	// other instances date the key by its file
+	info, err := os.Stat(k.keyPath(key.Id))
+	if err != nil {
+		return err
+	}
+	key.CreatedAt = info.ModTime()
+
+	return nil
+}
+
+// lock takes the rotation lock of the key directory: a file that exists
+// while an instance rotates or prunes keys. It returns a function that
+// releases it.
+func (k *Keyring) lock() (func(), error) {
+	path := filepath.Join(k.dir, lockFile)
+	deadline := time.Now().Add(lockWait)
+
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		info, err := os.Stat(path)
+		if err == nil && time.Since(info.ModTime()) > lockStale {
+			_ = os.Remove(path)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for another instance to rotate the signing keys")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func generateKey(algorithm string) (*Key, error) {
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newKey(private, time.Now())
+}
+
+// newKey wraps a private key, using its JWK thumbprint as the key id
+func newKey(private any, createdAt time.Time) (*Key, error) {
+	key := &Key{
+		Private:   private,
+		CreatedAt: createdAt,
+	}
+
+	switch p := private.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm = RS256
+		key.Public = &p.PublicKey
+	case *ecdsa.PrivateKey:
+		if p.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 EC keys are supported")
+		}
+		key.Algorithm = ES256
+		key.Public = &p.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	jwk, err := key.JWK()
+	if err != nil {
+		return nil, err
+	}
+	key.Id = jwk.Thumbprint()
+
+	return key, nil
+}
@@ -0,0 +1,159 @@
+package keyring
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newInstance is a keyring as one instance of the API sets it up
+func newInstance(t *testing.T, dir string, retention time.Duration) *Keyring {
+	t.Helper()
+
+	k, err := New(ES256, dir, 24*time.Hour, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+// assertVerifies checks that a token signed by one instance verifies on another
+func assertVerifies(t *testing.T, signer, verifier *Keyring) {
+	t.Helper()
+
+	signed, err := signer.Sign(jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{Subject: "1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = jwt.Parse(signed, verifier.Keyfunc)
+	if err != nil {
+		t.Errorf("token signed with %s does not verify: %v", signer.Current().Id, err)
+	}
+}
+
+func keyFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return paths
+}
+
+func TestInstancesShareKeys(t *testing.T) {
+	dir := t.TempDir()
+	a := newInstance(t, dir, time.Hour)
+	b := newInstance(t, dir, time.Hour)
+
+	if a.Current().Id != b.Current().Id {
+		t.Fatal("instances started on the same directory use different keys")
+	}
+
+	err := a.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b learns of the new key from the kid of the token
+	assertVerifies(t, a, b)
+	assertVerifies(t, b, a)
+
+	// and signs with it once it has read the directory again
+	err = b.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Current().Id != b.Current().Id {
+		t.Error("instances sign with different keys after reading the directory")
+	}
+	if len(b.Keys()) != 2 {
+		t.Errorf("b publishes %d keys, want both", len(b.Keys()))
+	}
+}
+
+func TestRotationKeepsKeysInUse(t *testing.T) {
+	dir := t.TempDir()
+	retention := 200 * time.Millisecond
+	a := newInstance(t, dir, retention)
+	b := newInstance(t, dir, retention)
+
+	err := a.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * retention)
+
+	// b has never read a's key, and must neither delete it nor stop
+	// trusting it: it is what a signs with
+	err = b.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(a.keyPath(a.Current().Id))
+	if err != nil {
+		t.Errorf("the current key of another instance was deleted: %v", err)
+	}
+	assertVerifies(t, a, b)
+
+	// the first key was replaced longer than the retention ago
+	if n := len(keyFiles(t, dir)); n != 2 {
+		t.Errorf("%d key files left, want 2", n)
+	}
+}
+
+func TestConcurrentStart(t *testing.T) {
+	dir := t.TempDir()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := New(ES256, dir, 24*time.Hour, time.Hour)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(keyFiles(t, dir)); n != 1 {
+		t.Errorf("instances starting at once made %d keys, want 1", n)
+	}
+}
+
+func TestStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	k := newInstance(t, dir, time.Hour)
+
+	// left behind by an instance that crashed while rotating
+	path := filepath.Join(dir, lockFile)
+	err := os.WriteFile(path, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * lockStale)
+	err = os.Chtimes(path, old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = k.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate with a stale lock: %v", err)
+	}
+
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Error("the lock was not released")
+	}
+}
@@ -0,0 +1,94 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeServer accepts one SMTP session on a local port, and sends the
+// commands and message it received on the channel once the session ends
+func fakeServer(t *testing.T) (int, <-chan []string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan []string, 1)
+	go func() {
+		var lines []string
+		defer func() { received <- lines }()
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 OK")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				reply("250 localhost")
+			case line == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSMTPEnvelopeSender(t *testing.T) {
+	port, received := fakeServer(t)
+
+	m := &SMTP{Host: "127.0.0.1", Port: port, From: "Go Movies <no-reply@example.com>"}
+	err := m.Send(Message{To: "ann@example.com", Subject: "Hi", Body: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := <-received
+	session := strings.Join(lines, "\n")
+
+	// the display name is only valid in the header
+	if !strings.Contains(session, "MAIL FROM:<no-reply@example.com>") {
+		t.Errorf("envelope sender is not the bare address:\n%s", session)
+	}
+	if !strings.Contains(session, "From: Go Movies <no-reply@example.com>") {
+		t.Errorf("From header lost the display name:\n%s", session)
+	}
+}
+
+func TestSMTPInvalidSender(t *testing.T) {
+	m := &SMTP{Host: "127.0.0.1", Port: 1, From: "Go Movies"}
+	err := m.Send(Message{To: "ann@example.com", Subject: "Hi", Body: "Hello"})
+	if err == nil {
+		t.Error("Send with a sender that is not an address succeeded")
+	}
+}
@@ -0,0 +1,386 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrations holds the schema changes, as pairs of NNNN_name.up.sql and
+// NNNN_name.down.sql files. Versions must be unique; they are applied in
+// order.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// lockKey identifies the advisory lock held while migrating, so that two
+// runners never migrate the same database at once
+const lockKey int64 = 4_271_902_113
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// Migration is one versioned change to the schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and when it was applied, if it was
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies the embedded migrations to a Postgres database. Each
+// migration runs in its own transaction together with the update of the
+// schema_migrations table, so a failing migration leaves no trace.
+type Migrator struct {
+	Db *sql.DB
+
+	// Log, if set, is called for every migration applied or rolled back
+	Log func(format string, args ...any)
+
+	migrations []Migration
+}
+
+func New(db *sql.DB) (*Migrator, error) {
+	list, err := load(migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{Db: db, migrations: list}, nil
+}
+
+// load reads the migrations of a directory, sorted by version
+func load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		name := file[len("migrations/"):]
+		match := fileName.FindStringSubmatch(name)
+		if match == nil {
+			return nil, fmt.Errorf("migration %s is not named NNNN_name.up.sql or NNNN_name.down.sql", name)
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		if version == 0 {
+			return nil, fmt.Errorf("migration %s: versions start at 1", name)
+		}
+
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %s: version %d is already used by %s", name, version, m.Name)
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	var list []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+
+	return list, nil
+}
+
+// Latest is the version of the last migration
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down rolls back the last applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		current := 0
+		for version := range applied {
+			if version > current {
+				current = version
+			}
+		}
+		if current == 0 {
+			return nil
+		}
+
+		// roll back to the migration before the current one
+		target := 0
+		for _, migration := range m.migrations {
+			if migration.Version < current && applied[migration.Version] != nil {
+				target = migration.Version
+			}
+		}
+
+		return m.migrate(ctx, conn, applied, target)
+	})
+}
+
+// To migrates up or down to version, so that exactly the migrations up to
+// and including it are applied. Version 0 rolls back every migration.
+func (m *Migrator) To(ctx context.Context, version int) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		return m.migrate(ctx, conn, applied, version)
+	})
+}
+
+// Baseline records the migrations up to and including version as applied,
+// without running them. It is for databases whose schema was created
+// before migrations existed, e.g. from the old pg_dump, which is version 1,
+// and refuses to run on a database that has any migration applied.
+func (m *Migrator) Baseline(ctx context.Context, version int) error {
+	if m.find(version) == nil {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(applied) > 0 {
+			return errors.New("the database already has migrations applied")
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, migration := range m.migrations {
+			if migration.Version > version {
+				break
+			}
+
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return err
+			}
+			m.log("marked %d_%s as applied", migration.Version, migration.Name)
+		}
+
+		return tx.Commit()
+	})
+}
+
+// Status lists every known migration, and any applied migration this
+// binary does not know about, by version
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var list []Status
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			list = append(list, Status{Migration: migration, AppliedAt: applied[migration.Version]})
+			delete(applied, migration.Version)
+		}
+
+		for version, at := range applied {
+			list = append(list, Status{Migration: Migration{Version: version, Name: "(unknown)"}, AppliedAt: at})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+
+	return list, nil
+}
+
+// migrate rolls back the applied migrations above target, newest first, and
+// then applies the pending ones up to it, oldest first
+func (m *Migrator) migrate(ctx context.Context, conn *sql.Conn, applied map[int]*time.Time, target int) error {
+	var down []int
+	for version := range applied {
+		if version > target {
+			down = append(down, version)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(down)))
+
+	for _, version := range down {
+		migration := m.find(version)
+		if migration == nil {
+			return fmt.Errorf("migration %d is applied, but unknown to this version of the API", version)
+		}
+
+		err := m.run(ctx, conn, migration.Down, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+		if err != nil {
+			return fmt.Errorf("rolling back migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		m.log("rolled back %d_%s", migration.Version, migration.Name)
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version > target || applied[migration.Version] != nil {
+			continue
+		}
+
+		err := m.run(ctx, conn, migration.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+		if err != nil {
+			return fmt.Errorf("applying migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		m.log("applied %d_%s", migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+// run executes the script of a migration and records it in one transaction
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// without arguments the statements of the script are sent as one
+	// simple query, which may hold many of them
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// locked runs fn on a connection that holds the migration lock, after making
+// sure the schema_migrations table exists. Other runners wait for the lock
+// until ctx is done.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.Db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the lock belongs to the session, so it must be taken and released on
+	// the same connection
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return fmt.Errorf("waiting for the migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version integer PRIMARY KEY,
+			name character varying(255) NOT NULL,
+			applied_at timestamp without time zone DEFAULT now() NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// appliedVersions returns when each applied migration was applied
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]*time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]*time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		err := rows.Scan(&version, &at)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = &at
+	}
+
+	return applied, rows.Err()
+}
+
+func (m *Migrator) find(version int) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) log(format string, args ...any) {
+	if m.Log != nil {
+		m.Log(format, args...)
+	}
+}
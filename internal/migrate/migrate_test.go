@@ -0,0 +1,193 @@
+package migrate
+
+import (
+	"backend/internal/pgtest"
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	m, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// versions are numbered without gaps, so that baseline N means the same
+	// schema everywhere
+	for i, migration := range m.migrations {
+		if migration.Version != i+1 {
+			t.Fatalf("migration %d_%s: want version %d", migration.Version, migration.Name, i+1)
+		}
+	}
+
+	if m.Latest() != len(m.migrations) {
+		t.Errorf("Latest() = %d, want %d", m.Latest(), len(m.migrations))
+	}
+}
+
+func TestLoadRejectsBrokenMigrations(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{
+			name: "missing down",
+			files: fstest.MapFS{
+				"migrations/0001_a.up.sql": {Data: []byte("SELECT 1")},
+			},
+			want: "needs both",
+		},
+		{
+			name: "bad name",
+			files: fstest.MapFS{
+				"migrations/1-a.sql": {Data: []byte("SELECT 1")},
+			},
+			want: "is not named",
+		},
+		{
+			name: "version zero",
+			files: fstest.MapFS{
+				"migrations/0000_a.up.sql": {Data: []byte("SELECT 1")},
+			},
+			want: "start at 1",
+		},
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"migrations/0001_a.up.sql":   {Data: []byte("SELECT 1")},
+				"migrations/0001_a.down.sql": {Data: []byte("SELECT 1")},
+				"migrations/0001_b.up.sql":   {Data: []byte("SELECT 1")},
+			},
+			want: "already used",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(tt.files)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("load() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpAndDown(t *testing.T) {
+	db := pgtest.New(t)
+	ctx := context.Background()
+
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	assertApplied(t, m, m.Latest())
+
+	// every down migration must undo its up migration, so that they can be
+	// applied again
+	err = m.To(ctx, 0)
+	if err != nil {
+		t.Fatalf("To(0): %v", err)
+	}
+	assertApplied(t, m, 0)
+
+	var tables int
+	err = db.QueryRow(`SELECT count(*) FROM pg_tables WHERE schemaname = 'public' AND tablename <> 'schema_migrations'`).Scan(&tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("%d tables are left after rolling back all migrations", tables)
+	}
+
+	err = m.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up after To(0): %v", err)
+	}
+
+	err = m.Down(ctx)
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	assertApplied(t, m, m.Latest()-1)
+}
+
+// TestBaseline upgrades a database created from the old pg_dump, which
+// has the schema of the first migration, with a user in it
+func TestBaseline(t *testing.T) {
+	db := pgtest.New(t)
+	ctx := context.Background()
+
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(m.find(1).Up)
+	if err != nil {
+		t.Fatalf("creating the old schema: %v", err)
+	}
+	_, err = db.Exec(`
+		INSERT INTO users (first_name, last_name, email, password, created_at, updated_at)
+		VALUES ('Old', 'User', 'old@example.com', 'hash', now(), now())
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tables exist, so running the first migration fails
+	err = m.Up(ctx)
+	if err == nil {
+		t.Fatal("Up succeeded on a database created from the dump")
+	}
+
+	err = m.Baseline(ctx, 1)
+	if err != nil {
+		t.Fatalf("Baseline: %v", err)
+	}
+
+	err = m.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up after Baseline: %v", err)
+	}
+	assertApplied(t, m, m.Latest())
+
+	// users from before email verification can still log in
+	var verified bool
+	err = db.QueryRow(`SELECT email_verified_at IS NOT NULL FROM users WHERE email = 'old@example.com'`).Scan(&verified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified {
+		t.Error("existing user is not verified after migrating")
+	}
+
+	err = m.Baseline(ctx, 1)
+	if err == nil {
+		t.Error("Baseline succeeded on a migrated database")
+	}
+}
+
+// assertApplied checks that exactly the migrations up to version are applied
+func assertApplied(t *testing.T, m *Migrator, version int) {
+	t.Helper()
+
+	list, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range list {
+		applied := s.AppliedAt != nil
+		if applied != (s.Version <= version) {
+			t.Errorf("migration %d_%s: applied = %v, want %v", s.Version, s.Name, applied, s.Version <= version)
+		}
+	}
+}
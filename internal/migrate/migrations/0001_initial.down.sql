@@ -0,0 +1,8 @@
+--
+-- Drops the initial schema, and with it all data
+--
+
+DROP TABLE IF EXISTS public.movies_genres;
+DROP TABLE IF EXISTS public.movies;
+DROP TABLE IF EXISTS public.genres;
+DROP TABLE IF EXISTS public.users;
@@ -0,0 +1,9 @@
+--
+-- The pg_trgm extension is left in place, as it may have been installed
+-- before
+--
+
+DROP INDEX IF EXISTS public.movies_title_trgm_idx;
+DROP INDEX IF EXISTS public.movies_search_vector_idx;
+
+ALTER TABLE public.movies DROP COLUMN IF EXISTS search_vector;
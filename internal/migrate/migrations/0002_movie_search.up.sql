@@ -0,0 +1,13 @@
+--
+-- Full-text search over movies: a weighted tsvector of title and
+-- description, and trigram matching of titles to catch typos
+--
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA public;
+
+ALTER TABLE public.movies
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS ((setweight(to_tsvector('english'::regconfig, (COALESCE(title, ''::character varying))::text), 'A'::"char") || setweight(to_tsvector('english'::regconfig, COALESCE(description, ''::text)), 'B'::"char"))) STORED;
+
+CREATE INDEX movies_search_vector_idx ON public.movies USING gin (search_vector);
+
+CREATE INDEX movies_title_trgm_idx ON public.movies USING gin (title public.gin_trgm_ops);
@@ -0,0 +1,9 @@
+--
+-- Users have a role: viewer, editor or admin. Existing users become
+-- viewers; promote admins with, e.g.:
+--
+--   UPDATE users SET role = 'admin' WHERE email = '...';
+--
+
+ALTER TABLE public.users
+    ADD COLUMN role character varying(20) DEFAULT 'viewer'::character varying NOT NULL;
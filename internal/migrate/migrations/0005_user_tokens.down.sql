@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS public.user_tokens;
+
+ALTER TABLE public.users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE public.users DROP COLUMN IF EXISTS email_verified_at;
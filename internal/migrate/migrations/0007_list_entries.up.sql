@@ -0,0 +1,34 @@
+--
+-- Each user keeps ordered lists of movies, such as their watchlist
+--
+
+CREATE TABLE public.list_entries (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    list character varying(20) NOT NULL,
+    movie_id integer NOT NULL,
+    "position" integer NOT NULL,
+    watched_at timestamp without time zone,
+    created_at timestamp without time zone
+);
+
+ALTER TABLE public.list_entries ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.list_entries_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+);
+
+ALTER TABLE ONLY public.list_entries
+    ADD CONSTRAINT list_entries_pkey PRIMARY KEY (id);
+
+ALTER TABLE ONLY public.list_entries
+    ADD CONSTRAINT list_entries_user_id_list_movie_id_key UNIQUE (user_id, list, movie_id);
+
+ALTER TABLE ONLY public.list_entries
+    ADD CONSTRAINT list_entries_movie_id_fkey FOREIGN KEY (movie_id) REFERENCES public.movies(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.list_entries
+    ADD CONSTRAINT list_entries_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
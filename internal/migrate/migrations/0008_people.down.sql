@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.credits;
+DROP TABLE IF EXISTS public.people;
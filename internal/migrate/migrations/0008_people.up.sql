@@ -0,0 +1,56 @@
+--
+-- People and their credits on movies, as cast or crew
+--
+
+CREATE TABLE public.people (
+    id integer NOT NULL,
+    name character varying(255) NOT NULL,
+    birthday date,
+    biography text DEFAULT ''::text NOT NULL,
+    image character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+ALTER TABLE public.people ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.people_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+);
+
+ALTER TABLE ONLY public.people
+    ADD CONSTRAINT people_pkey PRIMARY KEY (id);
+
+CREATE TABLE public.credits (
+    id integer NOT NULL,
+    movie_id integer NOT NULL,
+    person_id integer NOT NULL,
+    type character varying(20) NOT NULL,
+    "character" character varying(255) DEFAULT ''::character varying NOT NULL,
+    billing integer DEFAULT 0 NOT NULL
+);
+
+ALTER TABLE public.credits ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.credits_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+);
+
+ALTER TABLE ONLY public.credits
+    ADD CONSTRAINT credits_pkey PRIMARY KEY (id);
+
+CREATE INDEX credits_movie_id_idx ON public.credits USING btree (movie_id, type, billing);
+
+CREATE INDEX credits_person_id_idx ON public.credits USING btree (person_id);
+
+ALTER TABLE ONLY public.credits
+    ADD CONSTRAINT credits_movie_id_fkey FOREIGN KEY (movie_id) REFERENCES public.movies(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.credits
+    ADD CONSTRAINT credits_person_id_fkey FOREIGN KEY (person_id) REFERENCES public.people(id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1 @@
+ALTER TABLE public.genres DROP CONSTRAINT IF EXISTS genres_genre_key;
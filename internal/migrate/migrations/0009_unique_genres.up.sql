@@ -0,0 +1,7 @@
+--
+-- Genre names are unique, so that renaming or adding one cannot create a
+-- duplicate. Merge any duplicates before applying this.
+--
+
+ALTER TABLE ONLY public.genres
+    ADD CONSTRAINT genres_genre_key UNIQUE (genre);
@@ -0,0 +1,7 @@
+--
+-- Movies have a version, incremented by every write to them or their
+-- genres, so that editors cannot overwrite changes they have not seen
+--
+
+ALTER TABLE public.movies
+    ADD COLUMN version integer DEFAULT 1 NOT NULL;
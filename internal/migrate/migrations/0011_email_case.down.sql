@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.users_email_lower_key;
+
+ALTER TABLE ONLY public.users
+    ADD CONSTRAINT users_email_key UNIQUE (email);
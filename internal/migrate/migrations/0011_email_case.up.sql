@@ -0,0 +1,9 @@
+--
+-- Emails are unique whatever their case, since mail servers treat
+-- Ann@example.com and ann@example.com as the same address. Merge any users
+-- whose emails only differ in case before applying this.
+--
+
+ALTER TABLE public.users DROP CONSTRAINT IF EXISTS users_email_key;
+
+CREATE UNIQUE INDEX users_email_lower_key ON public.users (lower(email));
@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"backend/internal/validator"
+	"errors"
+	"time"
+)
 
 type Movie struct {
 	Id          int       `json:"id"`
@@ -10,10 +14,16 @@ type Movie struct {
 	MpaaRating  string    `json:"mpaa_rating"`
 	Description string    `json:"description"`
 	Image       string    `json:"image"`
+	// Version is incremented on every change, and only read for a single movie
+	Version     int       `json:"version,omitempty"`
 	CreatedAt   time.Time `json:"-"`
 	UpdatedAt   time.Time `json:"-"`
 	Genres      []*Genre  `json:"genres,omitempty"`
 	GenresArray []int     `json:"genres_array,omitempty"`
+
+	// Rating and Cast are only filled in for a single movie
+	Rating *RatingSummary `json:"rating,omitempty"`
+	Cast   []*Credit      `json:"cast,omitempty"`
 }
 
 type Genre struct {
@@ -22,4 +32,40 @@ type Genre struct {
 	Checked   bool      `json:"checked"`
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
+
+	// MovieCount is only filled in when listing all genres
+	MovieCount *int `json:"movie_count,omitempty"`
+}
+
+var ErrDuplicateGenre = errors.New("genre already exists")
+
+// ErrEditConflict is returned when a movie is written or deleted at a
+// version other than its current one
+var ErrEditConflict = errors.New("movie was changed since it was read")
+
+// MovieSearchResult is a movie matched by a full text search. The highlight
+// and snippet wrap matching words in <mark> tags.
+type MovieSearchResult struct {
+	Movie
+	Rank           float64 `json:"rank"`
+	TitleHighlight string  `json:"title_highlight"`
+	Snippet        string  `json:"snippet"`
+}
+
+// ValidateGenre checks the name of a genre
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(validator.NotBlank(genre.Genre), "genre", "must be provided")
+	v.Check(validator.MaxLength(genre.Genre, 255), "genre", "must be at most 255 characters")
+}
+
+// ValidateMovie checks the fields of a movie that editors can set, against
+// the columns they are stored in
+func ValidateMovie(v *validator.Validator, movie *Movie) {
+	v.Check(validator.NotBlank(movie.Title), "title", "must be provided")
+	v.Check(validator.MaxLength(movie.Title, 512), "title", "must be at most 512 characters")
+	v.Check(!movie.ReleaseDate.IsZero(), "release_date", "must be provided")
+	v.Check(movie.RunTime > 0, "runtime", "must be a positive number of minutes")
+	v.Check(validator.NotBlank(movie.MpaaRating), "mpaa_rating", "must be provided")
+	v.Check(validator.MaxLength(movie.MpaaRating, 10), "mpaa_rating", "must be at most 10 characters")
+	v.Check(validator.NotBlank(movie.Description), "description", "must be provided")
 }
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// The lists a user can keep movies in
+const (
+	ListWatchlist = "watchlist"
+	ListFavorites = "favorites"
+)
+
+var ErrInvalidOrder = errors.New("order must list each movie of the list once")
+
+// ListEntry is a movie on one of a user's lists. It has the same fields as
+// a movie in a listing, plus its place on the list.
+type ListEntry struct {
+	Movie
+	Position  int        `json:"position"`
+	WatchedAt *time.Time `json:"watched_at"`
+	AddedAt   time.Time  `json:"added_at"`
+}
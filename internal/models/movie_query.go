@@ -0,0 +1,127 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Columns that movie listings can be sorted by
+const (
+	SortTitle       = "title"
+	SortReleaseDate = "release_date"
+	SortRunTime     = "runtime"
+	SortCreatedAt   = "created_at"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// MovieQuery describes a page of a filtered, sorted movie listing.
+// Zero values mean "no filter".
+type MovieQuery struct {
+	Sort string
+	Desc bool
+
+	// Offset pagination. Page starts at 1 and is ignored when Cursor is set.
+	Page  int
+	Limit int
+
+	// Keyset pagination
+	Cursor *MovieCursor
+
+	TitleContains  string
+	Ratings        []string
+	YearFrom       int
+	YearTo         int
+	RunTimeMin     int
+	RunTimeMax     int
+	Genres         []int
+	MatchAllGenres bool
+}
+
+// MovieCursor marks a position in a sorted listing. Rows are returned after
+// (or, when Before is set, before) the row with this sort value and id.
+type MovieCursor struct {
+	Value  string `json:"v"`
+	Id     int    `json:"id"`
+	Before bool   `json:"b,omitempty"`
+}
+
+// MoviePage is one page of a listing, plus the number of rows matching the filters
+type MoviePage struct {
+	Movies  []*Movie
+	Total   int
+	HasNext bool
+	HasPrev bool
+}
+
+// Offset returns the number of rows to skip for offset pagination
+func (q MovieQuery) Offset() int {
+	if q.Cursor != nil || q.Page < 1 {
+		return 0
+	}
+
+	return (q.Page - 1) * q.Limit
+}
+
+// SortValue returns the value of the sort column for a movie, as stored in a cursor
+func (q MovieQuery) SortValue(m *Movie) string {
+	switch q.Sort {
+	case SortReleaseDate:
+		return m.ReleaseDate.Format(time.RFC3339Nano)
+	case SortRunTime:
+		return strconv.Itoa(m.RunTime)
+	case SortCreatedAt:
+		return m.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		return m.Title
+	}
+}
+
+// CursorValue converts the cursor's sort value to the type of the sort column
+func (q MovieQuery) CursorValue() (any, error) {
+	if q.Cursor == nil {
+		return nil, nil
+	}
+
+	switch q.Sort {
+	case SortReleaseDate, SortCreatedAt:
+		t, err := time.Parse(time.RFC3339Nano, q.Cursor.Value)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return t, nil
+	case SortRunTime:
+		n, err := strconv.Atoi(q.Cursor.Value)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return n, nil
+	default:
+		return q.Cursor.Value, nil
+	}
+}
+
+// Encode returns the cursor as an opaque, URL safe token
+func (c MovieCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeMovieCursor(token string) (*MovieCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c MovieCursor
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	return &c, nil
+}
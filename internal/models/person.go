@@ -0,0 +1,85 @@
+package models
+
+import (
+	"backend/internal/validator"
+	"time"
+)
+
+// The parts a person can have in making a movie
+const (
+	CreditCast            = "cast"
+	CreditDirector        = "director"
+	CreditWriter          = "writer"
+	CreditProducer        = "producer"
+	CreditComposer        = "composer"
+	CreditCinematographer = "cinematographer"
+	CreditEditor          = "editor"
+)
+
+var creditTypes = []string{
+	CreditCast,
+	CreditDirector,
+	CreditWriter,
+	CreditProducer,
+	CreditComposer,
+	CreditCinematographer,
+	CreditEditor,
+}
+
+// TopBilledCast is the number of cast members shown with a movie
+const TopBilledCast = 10
+
+// Person is someone who worked on movies, in front of or behind the camera
+type Person struct {
+	Id        int        `json:"id"`
+	Name      string     `json:"name"`
+	Birthday  *time.Time `json:"birthday"`
+	Biography string     `json:"biography"`
+	Image     string     `json:"image"`
+	CreatedAt time.Time  `json:"-"`
+	UpdatedAt time.Time  `json:"-"`
+
+	// Credits is the filmography, only filled in for a single person
+	Credits []*Credit `json:"credits,omitempty"`
+}
+
+// Credit is a person's part in a movie. Character and billing order only
+// apply to the cast. Depending on which side it is read from, a credit
+// carries the person or the movie.
+type Credit struct {
+	Id        int     `json:"id"`
+	MovieId   int     `json:"movie_id"`
+	PersonId  int     `json:"person_id"`
+	Type      string  `json:"type"`
+	Character string  `json:"character"`
+	Billing   int     `json:"billing"`
+	Person    *Person `json:"person,omitempty"`
+	Movie     *Movie  `json:"movie,omitempty"`
+}
+
+func ValidCreditType(t string) bool {
+	for _, ct := range creditTypes {
+		if ct == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidatePerson checks the fields of a person that editors can set
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(validator.NotBlank(person.Name), "name", "must be provided")
+	v.Check(validator.MaxLength(person.Name, 255), "name", "must be at most 255 characters")
+	v.Check(validator.MaxLength(person.Image, 255), "image", "must be at most 255 characters")
+	v.Check(person.Birthday == nil || person.Birthday.Before(time.Now()), "birthday", "must be in the past")
+}
+
+// ValidateCredit checks the fields of a credit that editors can set
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.MovieId > 0, "movie_id", "must be provided")
+	v.Check(ValidCreditType(credit.Type), "type", "must be one of cast, director, writer, producer, composer, cinematographer or editor")
+	v.Check(validator.MaxLength(credit.Character, 255), "character", "must be at most 255 characters")
+	v.Check(credit.Type == CreditCast || credit.Character == "", "character", "only applies to the cast")
+	v.Check(credit.Billing >= 0, "billing", "must not be negative")
+}
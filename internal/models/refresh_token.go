@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// RefreshToken is an issued refresh token. Only a hash of the token is kept.
+// Every token rotated from the same login shares a FamilyId, so the whole
+// chain can be revoked at once.
+type RefreshToken struct {
+	Id        int
+	UserId    int
+	TokenHash string
+	FamilyId  string
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
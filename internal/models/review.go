@@ -0,0 +1,40 @@
+package models
+
+import (
+	"backend/internal/validator"
+	"errors"
+	"time"
+)
+
+// The range of ratings a user can give
+const (
+	MinRating = 1
+	MaxRating = 10
+)
+
+var ErrDuplicateReview = errors.New("movie already reviewed")
+
+// Review is one user's rating of a movie, with an optional text review.
+// A user reviews a movie at most once.
+type Review struct {
+	Id        int       `json:"id"`
+	MovieId   int       `json:"movie_id"`
+	UserId    int       `json:"user_id"`
+	Author    string    `json:"author"`
+	Rating    int       `json:"rating"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RatingSummary is the average of a movie's ratings
+type RatingSummary struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+// ValidateReview checks the fields of a review that its author can set
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= MinRating && review.Rating <= MaxRating, "rating", "must be between 1 and 10")
+	v.Check(validator.MaxLength(review.Body, 5000), "body", "must be at most 5000 characters")
+}
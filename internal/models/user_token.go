@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// What a user token can be used for
+const (
+	ScopeVerification  = "verification"
+	ScopePasswordReset = "password_reset"
+)
+
+// UserToken is a single use token sent to a user by email. Only a hash of
+// the token is kept.
+type UserToken struct {
+	Id        int
+	UserId    int
+	TokenHash string
+	Scope     string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
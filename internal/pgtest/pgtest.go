@@ -0,0 +1,63 @@
+// Package pgtest gives tests a Postgres database of their own.
+package pgtest
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+// EnvDsn names the environment variable with the connection string of the
+// Postgres server to test against, e.g.
+//
+//	API_TEST_DSN="host=localhost user=postgres password=postgres dbname=postgres" go test ./...
+//
+// The user needs to be allowed to create databases.
+const EnvDsn = "API_TEST_DSN"
+
+// New creates an empty database for the test, and drops it when the test
+// ends. The test is skipped if EnvDsn is not set.
+func New(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv(EnvDsn)
+	if dsn == "" {
+		t.Skipf("%s is not set", EnvDsn)
+	}
+
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", EnvDsn, err)
+	}
+
+	server := stdlib.OpenDB(*cfg)
+	t.Cleanup(func() { server.Close() })
+
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	name := "api_test_" + hex.EncodeToString(b)
+
+	_, err = server.Exec(`CREATE DATABASE ` + name)
+	if err != nil {
+		t.Fatalf("creating the test database: %v", err)
+	}
+
+	cfg.Database = name
+	db := stdlib.OpenDB(*cfg)
+
+	// cleanups run last in first out: close the pool before dropping
+	t.Cleanup(func() {
+		_, err := server.Exec(`DROP DATABASE IF EXISTS ` + name)
+		if err != nil {
+			t.Errorf("dropping the test database: %v", err)
+		}
+	})
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
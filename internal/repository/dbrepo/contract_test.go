@@ -0,0 +1,1131 @@
+package dbrepo_test
+
+import (
+	"backend/internal/migrate"
+	"backend/internal/models"
+	"backend/internal/pgtest"
+	"backend/internal/repository"
+	"backend/internal/repository/dbrepo"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The contract tests describe the behaviour every DatabaseRepo must have.
+// They run against the memory repository, and against Postgres when
+// pgtest.EnvDsn is set, so that the two cannot drift apart.
+
+func TestMemoryDbRepo(t *testing.T) {
+	testContract(t, func(t *testing.T) repository.DatabaseRepo {
+		return dbrepo.NewMemoryDbRepo()
+	})
+}
+
+func TestPostgresDbRepo(t *testing.T) {
+	if os.Getenv(pgtest.EnvDsn) == "" {
+		t.Skipf("%s is not set", pgtest.EnvDsn)
+	}
+
+	testContract(t, func(t *testing.T) repository.DatabaseRepo {
+		db := pgtest.New(t)
+
+		m, err := migrate.New(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Up(context.Background())
+		if err != nil {
+			t.Fatalf("migrating the test database: %v", err)
+		}
+
+		return &dbrepo.PostgresDbRepo{Db: db}
+	})
+}
+
+// testContract runs every contract test on a fresh, empty repository
+func testContract(t *testing.T, newRepo func(t *testing.T) repository.DatabaseRepo) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo repository.DatabaseRepo)
+	}{
+		{"Genres", testGenres},
+		{"MergeGenres", testMergeGenres},
+		{"Movies", testMovies},
+		{"MovieVersions", testMovieVersions},
+		{"Listings", testListings},
+		{"FilterMovies", testFilterMovies},
+		{"SearchMovies", testSearchMovies},
+		{"Reviews", testReviews},
+		{"People", testPeople},
+		{"Lists", testLists},
+		{"Users", testUsers},
+		{"UserTokens", testUserTokens},
+		{"RefreshTokens", testRefreshTokens},
+		{"WithTx", testWithTx},
+		{"WithTxFailure", testWithTxFailure},
+		{"Seed", testSeed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+func testGenres(t *testing.T, repo repository.DatabaseRepo) {
+	drama := insertGenre(t, repo, "Drama")
+	comedy := insertGenre(t, repo, "Comedy")
+
+	_, err := repo.InsertGenre(models.Genre{Genre: "Drama", CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	if !errors.Is(err, models.ErrDuplicateGenre) {
+		t.Errorf("inserting a duplicate genre: error = %v, want ErrDuplicateGenre", err)
+	}
+
+	err = repo.UpdateGenre(models.Genre{Id: comedy, Genre: "Drama", UpdatedAt: time.Now()})
+	if !errors.Is(err, models.ErrDuplicateGenre) {
+		t.Errorf("renaming to a taken name: error = %v, want ErrDuplicateGenre", err)
+	}
+
+	err = repo.UpdateGenre(models.Genre{Id: comedy, Genre: "Black Comedy", UpdatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := repo.OneGenre(comedy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Genre != "Black Comedy" {
+		t.Errorf("renamed genre = %q, want %q", g.Genre, "Black Comedy")
+	}
+
+	_, err = repo.OneGenre(comedy + drama + 100)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("OneGenre of a missing genre: error = %v, want sql.ErrNoRows", err)
+	}
+
+	movie := insertMovie(t, repo, "Casablanca", 1942, drama)
+
+	genres, err := repo.AllGenres()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGenreNames(t, genres, "Black Comedy", "Drama")
+	for _, g := range genres {
+		want := 0
+		if g.Id == drama {
+			want = 1
+		}
+		if g.MovieCount == nil || *g.MovieCount != want {
+			t.Errorf("genre %q: MovieCount = %v, want %d", g.Genre, g.MovieCount, want)
+		}
+	}
+
+	before := oneMovie(t, repo, movie).Version
+	err = repo.DeleteGenre(drama)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := oneMovie(t, repo, movie)
+	if len(m.Genres) != 0 {
+		t.Errorf("movie still has %d genres after its genre was deleted", len(m.Genres))
+	}
+	if m.Version != before+1 {
+		t.Errorf("version after deleting the movie's genre = %d, want %d", m.Version, before+1)
+	}
+}
+
+func testMergeGenres(t *testing.T, repo repository.DatabaseRepo) {
+	scifi := insertGenre(t, repo, "Sci-Fi")
+	sciencefiction := insertGenre(t, repo, "Science Fiction")
+	horror := insertGenre(t, repo, "Horror")
+
+	alien := insertMovie(t, repo, "Alien", 1979, sciencefiction, horror)
+	// in both genres, so merging must not link it twice
+	dune := insertMovie(t, repo, "Dune", 2021, scifi, sciencefiction)
+
+	// a movie whose genres stay the same keeps its version
+	casablanca := insertMovie(t, repo, "Casablanca", 1942, horror)
+
+	versions := make(map[int]int)
+	for _, id := range []int{alien, dune, casablanca} {
+		versions[id] = oneMovie(t, repo, id).Version
+	}
+
+	err := repo.MergeGenres(sciencefiction, scifi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, bump := range map[int]int{alien: 1, dune: 1, casablanca: 0} {
+		m := oneMovie(t, repo, id)
+		if m.Version != versions[id]+bump {
+			t.Errorf("%s: version after merging = %d, want %d", m.Title, m.Version, versions[id]+bump)
+		}
+	}
+
+	_, err = repo.OneGenre(sciencefiction)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("merged genre still exists: error = %v", err)
+	}
+
+	for id, want := range map[int][]string{
+		alien: {"Horror", "Sci-Fi"},
+		dune:  {"Sci-Fi"},
+	} {
+		m, err := repo.OneMovie(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertGenreNames(t, m.Genres, want...)
+	}
+
+	err = repo.MergeGenres(sciencefiction, scifi)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("merging a missing genre: error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testMovies(t *testing.T, repo repository.DatabaseRepo) {
+	drama := insertGenre(t, repo, "Drama")
+	crime := insertGenre(t, repo, "Crime")
+	insertGenre(t, repo, "Western")
+
+	id := insertMovie(t, repo, "The Godfather", 1972, drama, crime)
+
+	m, err := repo.OneMovie(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "The Godfather" || m.RunTime != 120 || m.MpaaRating != "R" {
+		t.Errorf("OneMovie = %+v, not the movie inserted", m)
+	}
+	if got := m.ReleaseDate.Format("2006-01-02"); got != "1972-01-01" {
+		t.Errorf("release date = %s, want 1972-01-01", got)
+	}
+	assertGenreNames(t, m.Genres, "Crime", "Drama")
+
+	m, all, err := repo.OneMovieForEdit(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.GenresArray) != 2 {
+		t.Errorf("GenresArray = %v, want the ids of 2 genres", m.GenresArray)
+	}
+	assertGenreNames(t, all, "Crime", "Drama", "Western")
+
+	_, err = repo.OneMovie(id + 100)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("OneMovie of a missing movie: error = %v, want sql.ErrNoRows", err)
+	}
+	_, _, err = repo.OneMovieForEdit(id + 100)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("OneMovieForEdit of a missing movie: error = %v, want sql.ErrNoRows", err)
+	}
+
+	// a genre that does not exist fails the whole update
+	err = repo.UpdateMovieGenres(id, []int{drama, drama + crime + 100})
+	if err == nil {
+		t.Error("UpdateMovieGenres with a missing genre succeeded")
+	}
+	m, err = repo.OneMovie(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGenreNames(t, m.Genres, "Crime", "Drama")
+}
+
+func testMovieVersions(t *testing.T, repo repository.DatabaseRepo) {
+	genre := insertGenre(t, repo, "Drama")
+	id := insertMovie(t, repo, "Casablanca", 1942)
+
+	m := oneMovie(t, repo, id)
+	if m.Version != 1 {
+		t.Fatalf("new movie has version %d, want 1", m.Version)
+	}
+
+	m.Title = "Casablanca (1942)"
+	err := repo.UpdateMovie(*m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := oneMovie(t, repo, id).Version; v != 2 {
+		t.Errorf("version after UpdateMovie = %d, want 2", v)
+	}
+
+	// m is now stale
+	err = repo.UpdateMovie(*m)
+	if !errors.Is(err, models.ErrEditConflict) {
+		t.Errorf("UpdateMovie at a stale version: error = %v, want ErrEditConflict", err)
+	}
+
+	err = repo.UpdateMovieGenres(id, []int{genre})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := oneMovie(t, repo, id).Version; v != 3 {
+		t.Errorf("version after UpdateMovieGenres = %d, want 3", v)
+	}
+
+	missing := *m
+	missing.Id = id + 100
+	err = repo.UpdateMovie(missing)
+	if !errors.Is(err, models.ErrEditConflict) {
+		t.Errorf("UpdateMovie of a missing movie: error = %v, want ErrEditConflict", err)
+	}
+
+	err = repo.DeleteMovie(id, 2)
+	if !errors.Is(err, models.ErrEditConflict) {
+		t.Errorf("DeleteMovie at a stale version: error = %v, want ErrEditConflict", err)
+	}
+
+	err = repo.DeleteMovie(id, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.OneMovie(id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted movie: error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testListings(t *testing.T, repo repository.DatabaseRepo) {
+	drama := insertGenre(t, repo, "Drama")
+	scifi := insertGenre(t, repo, "Sci-Fi")
+
+	casablanca := insertMovie(t, repo, "Casablanca", 1942, drama)
+	alien := insertMovie(t, repo, "Alien", 1979, scifi)
+	brazil := insertMovie(t, repo, "Brazil", 1985, drama, scifi)
+
+	movies, err := repo.AllMovies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTitles(t, movies, "Alien", "Brazil", "Casablanca")
+
+	movies, err = repo.AllMovies(drama)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTitles(t, movies, "Brazil", "Casablanca")
+
+	genres, err := repo.GenresForMovies([]int{casablanca, alien, brazil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGenreNames(t, genres[brazil], "Drama", "Sci-Fi")
+	assertGenreNames(t, genres[alien], "Sci-Fi")
+
+	byGenre, err := repo.MoviesForGenres([]int{drama, scifi}, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTitles(t, byGenre[drama], "Brazil")
+	assertTitles(t, byGenre[scifi], "Alien")
+
+	byGenre, err = repo.MoviesForGenres([]int{drama}, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTitles(t, byGenre[drama], "Casablanca")
+}
+
+func testFilterMovies(t *testing.T, repo repository.DatabaseRepo) {
+	drama := insertGenre(t, repo, "Drama")
+	scifi := insertGenre(t, repo, "Sci-Fi")
+
+	alien := insertMovie(t, repo, "Alien", 1979, scifi)
+	insertMovie(t, repo, "Brazil", 1985, drama, scifi)
+	insertMovie(t, repo, "Casablanca", 1942, drama)
+	dune := insertMovie(t, repo, "Dune", 2021, scifi)
+
+	tests := []struct {
+		name  string
+		q     models.MovieQuery
+		want  []string
+		total int
+		next  bool
+	}{
+		{
+			name:  "first page",
+			q:     models.MovieQuery{Sort: models.SortTitle, Page: 1, Limit: 2},
+			want:  []string{"Alien", "Brazil"},
+			total: 4,
+			next:  true,
+		},
+		{
+			name:  "last page",
+			q:     models.MovieQuery{Sort: models.SortTitle, Page: 2, Limit: 2},
+			want:  []string{"Casablanca", "Dune"},
+			total: 4,
+		},
+		{
+			name:  "by release date, newest first",
+			q:     models.MovieQuery{Sort: models.SortReleaseDate, Desc: true, Page: 1, Limit: 10},
+			want:  []string{"Dune", "Brazil", "Alien", "Casablanca"},
+			total: 4,
+		},
+		{
+			name:  "years",
+			q:     models.MovieQuery{Sort: models.SortTitle, Page: 1, Limit: 10, YearFrom: 1970, YearTo: 1990},
+			want:  []string{"Alien", "Brazil"},
+			total: 2,
+		},
+		{
+			name:  "title",
+			q:     models.MovieQuery{Sort: models.SortTitle, Page: 1, Limit: 10, TitleContains: "AL"},
+			want:  []string{"Alien"},
+			total: 1,
+		},
+		{
+			name:  "any genre",
+			q:     models.MovieQuery{Sort: models.SortTitle, Page: 1, Limit: 10, Genres: []int{drama, scifi}},
+			want:  []string{"Alien", "Brazil", "Casablanca", "Dune"},
+			total: 4,
+		},
+		{
+			name:  "all genres",
+			q:     models.MovieQuery{Sort: models.SortTitle, Page: 1, Limit: 10, Genres: []int{drama, scifi}, MatchAllGenres: true},
+			want:  []string{"Brazil"},
+			total: 1,
+		},
+		{
+			name:  "after a cursor",
+			q:     models.MovieQuery{Sort: models.SortTitle, Limit: 2, Cursor: &models.MovieCursor{Value: "Alien", Id: alien}},
+			want:  []string{"Brazil", "Casablanca"},
+			total: 4,
+			next:  true,
+		},
+		{
+			name:  "before a cursor",
+			q:     models.MovieQuery{Sort: models.SortTitle, Limit: 2, Cursor: &models.MovieCursor{Value: "Dune", Id: dune, Before: true}},
+			want:  []string{"Brazil", "Casablanca"},
+			total: 4,
+			next:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := repo.FilterMovies(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertTitles(t, page.Movies, tt.want...)
+			if page.Total != tt.total {
+				t.Errorf("Total = %d, want %d", page.Total, tt.total)
+			}
+			if page.HasNext != tt.next {
+				t.Errorf("HasNext = %v, want %v", page.HasNext, tt.next)
+			}
+		})
+	}
+}
+
+func testSearchMovies(t *testing.T, repo repository.DatabaseRepo) {
+	insertMovie(t, repo, "The Godfather", 1972)
+	insertMovie(t, repo, "Casablanca", 1942)
+
+	results, err := repo.SearchMovies("godf", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Title != "The Godfather" {
+		t.Fatalf("searching a word prefix found %v, want The Godfather", searchTitles(results))
+	}
+	if !strings.Contains(results[0].TitleHighlight, "<mark>Godfather</mark>") {
+		t.Errorf("TitleHighlight = %q, want Godfather marked", results[0].TitleHighlight)
+	}
+
+	// a typo is still close enough to the title
+	results, err = repo.SearchMovies("godfahter", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Title != "The Godfather" {
+		t.Errorf("searching with a typo found %v, want The Godfather", searchTitles(results))
+	}
+
+	// every movie's description mentions "movie"
+	results, err = repo.SearchMovies("movie", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Errorf("search with limit 1 found %d movies", len(results))
+	}
+
+	// highlights are HTML, so markup in the text must come out escaped
+	movie := newMovie("Rosemary's Baby", 1968)
+	movie.Description = `<script>alert("x")</script> A young couple & their rosemary.`
+	_, err = repo.InsertMovie(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err = repo.SearchMovies("rosemary", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("searching rosemary found %v", searchTitles(results))
+	}
+	for _, html := range []string{results[0].TitleHighlight, results[0].Snippet} {
+		if strings.Contains(html, "<script") || strings.Contains(html, "'") || strings.Contains(html, " & ") {
+			t.Errorf("highlight %q is not HTML escaped", html)
+		}
+		if !strings.Contains(html, "<mark>") {
+			t.Errorf("highlight %q marks no match", html)
+		}
+	}
+
+	results, err = repo.SearchMovies("  !? ", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("searching only punctuation found %d movies", len(results))
+	}
+}
+
+func testReviews(t *testing.T, repo repository.DatabaseRepo) {
+	movie := insertMovie(t, repo, "Casablanca", 1942)
+	ann := insertUser(t, repo, "ann@example.com")
+	bob := insertUser(t, repo, "bob@example.com")
+
+	earlier := time.Now().Add(-time.Hour)
+	first := insertReview(t, repo, movie, ann, 6, earlier)
+	second := insertReview(t, repo, movie, bob, 9, time.Now())
+
+	_, err := repo.InsertReview(models.Review{MovieId: movie, UserId: ann, Rating: 1, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	if !errors.Is(err, models.ErrDuplicateReview) {
+		t.Errorf("reviewing a movie twice: error = %v, want ErrDuplicateReview", err)
+	}
+
+	rating, err := repo.MovieRating(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rating.Count != 2 || rating.Average != 7.5 {
+		t.Errorf("MovieRating = %+v, want 2 ratings averaging 7.5", rating)
+	}
+
+	reviews, total, err := repo.ReviewsForMovie(movie, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Errorf("total = %d, want 2", total)
+	}
+	if len(reviews) != 1 || reviews[0].Id != second {
+		t.Fatalf("first page of reviews = %+v, want the newest review", reviews)
+	}
+	if reviews[0].Author != "Bob" {
+		t.Errorf("Author = %q, want Bob", reviews[0].Author)
+	}
+
+	err = repo.UpdateReview(models.Review{Id: first, Rating: 3, Body: "changed my mind", UpdatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	review, err := repo.OneReview(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if review.Rating != 3 || review.Body != "changed my mind" || review.Author != "Ann" {
+		t.Errorf("updated review = %+v", review)
+	}
+
+	err = repo.DeleteReview(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.OneReview(first)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted review: error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testPeople(t *testing.T, repo repository.DatabaseRepo) {
+	casablanca := insertMovie(t, repo, "Casablanca", 1942)
+	sabrina := insertMovie(t, repo, "Sabrina", 1954)
+
+	bogart := insertPerson(t, repo, "Humphrey Bogart")
+	bergman := insertPerson(t, repo, "Ingrid Bergman")
+
+	insertCredit(t, repo, models.Credit{MovieId: casablanca, PersonId: bergman, Type: models.CreditCast, Character: "Ilsa", Billing: 2})
+	rick := insertCredit(t, repo, models.Credit{MovieId: casablanca, PersonId: bogart, Type: models.CreditCast, Character: "Rick", Billing: 1})
+	insertCredit(t, repo, models.Credit{MovieId: sabrina, PersonId: bogart, Type: models.CreditCast, Character: "Linus", Billing: 1})
+
+	m := oneMovie(t, repo, casablanca)
+	if len(m.Cast) != 2 || m.Cast[0].Character != "Rick" || m.Cast[1].Character != "Ilsa" {
+		t.Fatalf("cast = %+v, want Rick then Ilsa", m.Cast)
+	}
+	if m.Cast[0].Person == nil || m.Cast[0].Person.Name != "Humphrey Bogart" {
+		t.Errorf("cast member has person %+v, want Humphrey Bogart", m.Cast[0].Person)
+	}
+
+	p, err := repo.OnePerson(bogart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Credits) != 2 || p.Credits[0].Movie == nil || p.Credits[0].Movie.Title != "Sabrina" {
+		t.Errorf("credits = %+v, want Sabrina then Casablanca", p.Credits)
+	}
+
+	// credits can only be changed through the person they belong to
+	ok, err := repo.UpdateCredit(models.Credit{Id: rick, PersonId: bergman, MovieId: casablanca, Type: models.CreditCast})
+	if err != nil || ok {
+		t.Errorf("UpdateCredit through another person = %v, %v; want false", ok, err)
+	}
+	ok, err = repo.UpdateCredit(models.Credit{Id: rick, PersonId: bogart, MovieId: casablanca, Type: models.CreditCast, Character: "Richard Blaine", Billing: 1})
+	if err != nil || !ok {
+		t.Fatalf("UpdateCredit = %v, %v; want true", ok, err)
+	}
+	ok, err = repo.DeleteCredit(bergman, rick)
+	if err != nil || ok {
+		t.Errorf("DeleteCredit through another person = %v, %v; want false", ok, err)
+	}
+
+	if c := oneMovie(t, repo, casablanca).Cast[0]; c.Character != "Richard Blaine" {
+		t.Errorf("character after UpdateCredit = %q", c.Character)
+	}
+
+	err = repo.DeletePerson(bogart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cast := oneMovie(t, repo, casablanca).Cast; len(cast) != 1 {
+		t.Errorf("%d cast members left after deleting a person, want 1", len(cast))
+	}
+	_, err = repo.OnePerson(bogart)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted person: error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testLists(t *testing.T, repo repository.DatabaseRepo) {
+	user := insertUser(t, repo, "ann@example.com")
+	alien := insertMovie(t, repo, "Alien", 1979)
+	brazil := insertMovie(t, repo, "Brazil", 1985)
+	dune := insertMovie(t, repo, "Dune", 2021)
+
+	for _, id := range []int{dune, alien, brazil} {
+		added, err := repo.AddListEntry(user, models.ListWatchlist, id)
+		if err != nil || !added {
+			t.Fatalf("AddListEntry = %v, %v; want true", added, err)
+		}
+	}
+
+	added, err := repo.AddListEntry(user, models.ListWatchlist, alien)
+	if err != nil || added {
+		t.Errorf("adding a movie twice = %v, %v; want false", added, err)
+	}
+
+	assertList(t, repo, user, models.ListWatchlist, "Dune", "Alien", "Brazil")
+	assertList(t, repo, user, models.ListFavorites)
+
+	err = repo.ReorderList(user, models.ListWatchlist, []int{alien, brazil})
+	if !errors.Is(err, models.ErrInvalidOrder) {
+		t.Errorf("reordering without every movie: error = %v, want ErrInvalidOrder", err)
+	}
+	err = repo.ReorderList(user, models.ListWatchlist, []int{alien, brazil, dune})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertList(t, repo, user, models.ListWatchlist, "Alien", "Brazil", "Dune")
+
+	watched := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	ok, err := repo.SetListEntryWatched(user, models.ListWatchlist, brazil, &watched)
+	if err != nil || !ok {
+		t.Fatalf("SetListEntryWatched = %v, %v; want true", ok, err)
+	}
+	entries, err := repo.ListEntries(user, models.ListWatchlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[1].WatchedAt == nil || !entries[1].WatchedAt.Equal(watched) {
+		t.Errorf("WatchedAt = %v, want %v", entries[1].WatchedAt, watched)
+	}
+
+	removed, err := repo.RemoveListEntry(user, models.ListWatchlist, alien)
+	if err != nil || !removed {
+		t.Fatalf("RemoveListEntry = %v, %v; want true", removed, err)
+	}
+	removed, err = repo.RemoveListEntry(user, models.ListWatchlist, alien)
+	if err != nil || removed {
+		t.Errorf("removing a movie twice = %v, %v; want false", removed, err)
+	}
+
+	// deleting a movie takes it off every list
+	err = repo.DeleteMovie(dune, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertList(t, repo, user, models.ListWatchlist, "Brazil")
+}
+
+func testUsers(t *testing.T, repo repository.DatabaseRepo) {
+	id := insertUser(t, repo, "ann@example.com")
+
+	_, err := repo.InsertUser(models.User{FirstName: "Ann", Email: "ann@example.com", Role: models.RoleViewer, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	if !errors.Is(err, models.ErrDuplicateEmail) {
+		t.Errorf("inserting a duplicate email: error = %v, want ErrDuplicateEmail", err)
+	}
+	_, err = repo.InsertUser(models.User{FirstName: "Ann", Email: "Ann@Example.com", Role: models.RoleViewer, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	if !errors.Is(err, models.ErrDuplicateEmail) {
+		t.Errorf("inserting a duplicate email in another case: error = %v, want ErrDuplicateEmail", err)
+	}
+
+	u, err := repo.GetUserByEmail("ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Id != id || u.Role != models.RoleViewer || u.EmailVerifiedAt != nil {
+		t.Errorf("GetUserByEmail = %+v, not the user inserted", u)
+	}
+
+	u, err = repo.GetUserByEmail("ANN@example.com")
+	if err != nil || u.Id != id {
+		t.Errorf("GetUserByEmail in another case = %+v, %v; want the user inserted", u, err)
+	}
+
+	_, err = repo.GetUserByEmail("nobody@example.com")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByEmail of a missing user: error = %v, want sql.ErrNoRows", err)
+	}
+	_, err = repo.GetUserById(id + 100)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserById of a missing user: error = %v, want sql.ErrNoRows", err)
+	}
+
+	err = repo.VerifyUser(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.UpdateUserPassword(id, "new hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.UpdateUserRole(id, models.RoleEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err = repo.GetUserById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.EmailVerifiedAt == nil {
+		t.Error("user is not verified after VerifyUser")
+	}
+	if u.Password != "new hash" {
+		t.Errorf("password = %q after UpdateUserPassword", u.Password)
+	}
+	if u.Role != models.RoleEditor {
+		t.Errorf("role = %q after UpdateUserRole", u.Role)
+	}
+}
+
+func testUserTokens(t *testing.T, repo repository.DatabaseRepo) {
+	user := insertUser(t, repo, "ann@example.com")
+
+	insertUserToken(t, repo, user, "valid", models.ScopePasswordReset, time.Hour)
+	insertUserToken(t, repo, user, "expired", models.ScopePasswordReset, -time.Hour)
+	insertUserToken(t, repo, user, "verify", models.ScopeVerification, time.Hour)
+
+	token, err := repo.ConsumeUserToken("valid", models.ScopePasswordReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.UserId != user {
+		t.Errorf("token belongs to user %d, want %d", token.UserId, user)
+	}
+
+	for _, tt := range []struct{ hash, scope, why string }{
+		{"valid", models.ScopePasswordReset, "a used token"},
+		{"expired", models.ScopePasswordReset, "an expired token"},
+		{"verify", models.ScopePasswordReset, "a token of another scope"},
+		{"unknown", models.ScopePasswordReset, "an unknown token"},
+	} {
+		_, err := repo.ConsumeUserToken(tt.hash, tt.scope)
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("consuming %s: error = %v, want sql.ErrNoRows", tt.why, err)
+		}
+	}
+
+	err = repo.DeleteUserTokens(user, models.ScopeVerification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.ConsumeUserToken("verify", models.ScopeVerification)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("consuming a deleted token: error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testRefreshTokens(t *testing.T, repo repository.DatabaseRepo) {
+	ann := insertUser(t, repo, "ann@example.com")
+	bob := insertUser(t, repo, "bob@example.com")
+
+	insertRefreshToken(t, repo, ann, "a1", "family-a")
+	insertRefreshToken(t, repo, ann, "a2", "family-a")
+	insertRefreshToken(t, repo, ann, "a3", "family-b")
+	insertRefreshToken(t, repo, bob, "b1", "family-c")
+
+	token, err := repo.GetRefreshTokenByHash("a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.UserId != ann || token.FamilyId != "family-a" || token.RevokedAt != nil {
+		t.Errorf("GetRefreshTokenByHash = %+v, not the token inserted", token)
+	}
+
+	_, err = repo.GetRefreshTokenByHash("unknown")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetRefreshTokenByHash of an unknown token: error = %v, want sql.ErrNoRows", err)
+	}
+
+	// only the first of two concurrent rotations wins
+	revoked, err := repo.RevokeRefreshToken(token.Id)
+	if err != nil || !revoked {
+		t.Fatalf("RevokeRefreshToken = %v, %v; want true", revoked, err)
+	}
+	revoked, err = repo.RevokeRefreshToken(token.Id)
+	if err != nil || revoked {
+		t.Errorf("revoking a token twice = %v, %v; want false", revoked, err)
+	}
+
+	err = repo.RevokeRefreshTokenFamily("family-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRevoked(t, repo, map[string]bool{"a1": true, "a2": true, "a3": false, "b1": false})
+
+	err = repo.RevokeUserRefreshTokens(ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRevoked(t, repo, map[string]bool{"a1": true, "a2": true, "a3": true, "b1": false})
+}
+
+func testWithTx(t *testing.T, repo repository.DatabaseRepo) {
+	genre := insertGenre(t, repo, "Drama")
+
+	var id int
+	err := repo.WithTx(func(tx repository.DatabaseRepo) error {
+		var err error
+		id, err = tx.InsertMovie(newMovie("Casablanca", 1942))
+		if err != nil {
+			return err
+		}
+
+		return tx.UpdateMovieGenres(id, []int{genre})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := oneMovie(t, repo, id)
+	assertGenreNames(t, m.Genres, "Drama")
+
+	// an error rolls back everything done in the transaction
+	errRollback := errors.New("roll back")
+	err = repo.WithTx(func(tx repository.DatabaseRepo) error {
+		m.Title = "Casablanca (1942)"
+		err := tx.UpdateMovie(*m)
+		if err != nil {
+			return err
+		}
+
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx error = %v, want the error fn returned", err)
+	}
+	if got := oneMovie(t, repo, id); got.Title != "Casablanca" || got.Version != m.Version {
+		t.Errorf("movie after a rolled back update = %q at version %d", got.Title, got.Version)
+	}
+}
+
+// testWithTxFailure fails a write halfway through a transaction, as
+// inserting a movie with a genre that does not exist does
+func testWithTxFailure(t *testing.T, repo repository.DatabaseRepo) {
+	genre := insertGenre(t, repo, "Drama")
+
+	var id int
+	err := repo.WithTx(func(tx repository.DatabaseRepo) error {
+		var err error
+		id, err = tx.InsertMovie(newMovie("Casablanca", 1942))
+		if err != nil {
+			t.Fatalf("InsertMovie: %v", err)
+		}
+
+		// the movie exists within the transaction
+		oneMovie(t, tx, id)
+
+		return tx.UpdateMovieGenres(id, []int{genre, genre + 100})
+	})
+	if err == nil {
+		t.Fatal("WithTx succeeded although UpdateMovieGenres failed")
+	}
+
+	_, err = repo.OneMovie(id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("movie inserted before the failure: error = %v, want sql.ErrNoRows", err)
+	}
+
+	movies, err := repo.AllMovies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != 0 {
+		t.Errorf("%d movies left after the transaction failed", len(movies))
+	}
+
+	// the repository is still usable
+	insertMovie(t, repo, "Casablanca", 1942, genre)
+}
+
+func testSeed(t *testing.T, repo repository.DatabaseRepo) {
+	err := dbrepo.Seed(repo, "../../../sql/seed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movies, err := repo.AllMovies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) == 0 {
+		t.Fatal("no movies after seeding")
+	}
+
+	m := oneMovie(t, repo, movies[0].Id)
+	if len(m.Genres) == 0 {
+		t.Errorf("seeded movie %q has no genres", m.Title)
+	}
+
+	err = dbrepo.Seed(repo, "../../../sql/seed.json")
+	if err == nil {
+		t.Error("seeding twice succeeded")
+	}
+}
+
+func insertGenre(t *testing.T, repo repository.DatabaseRepo, name string) int {
+	t.Helper()
+
+	id, err := repo.InsertGenre(models.Genre{Genre: name, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// newMovie returns a movie released on the first of January of year. Dates
+// are midnight UTC, which is how Postgres returns a date column.
+func newMovie(title string, year int) models.Movie {
+	return models.Movie{
+		Title:       title,
+		ReleaseDate: time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC),
+		RunTime:     120,
+		MpaaRating:  "R",
+		Description: "A movie called " + title + ".",
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+}
+
+func insertMovie(t *testing.T, repo repository.DatabaseRepo, title string, year int, genreIds ...int) int {
+	t.Helper()
+
+	id, err := repo.InsertMovie(newMovie(title, year))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(genreIds) > 0 {
+		err = repo.UpdateMovieGenres(id, genreIds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return id
+}
+
+func oneMovie(t *testing.T, repo repository.DatabaseRepo, id int) *models.Movie {
+	t.Helper()
+
+	m, err := repo.OneMovie(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// insertUser adds a viewer whose first name is the capitalised local part
+// of the email
+func insertUser(t *testing.T, repo repository.DatabaseRepo, email string) int {
+	t.Helper()
+
+	name := email[:strings.Index(email, "@")]
+	id, err := repo.InsertUser(models.User{
+		FirstName: strings.ToUpper(name[:1]) + name[1:],
+		LastName:  "Tester",
+		Email:     email,
+		Password:  "hash",
+		Role:      models.RoleViewer,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func insertReview(t *testing.T, repo repository.DatabaseRepo, movieId, userId, rating int, at time.Time) int {
+	t.Helper()
+
+	id, err := repo.InsertReview(models.Review{
+		MovieId:   movieId,
+		UserId:    userId,
+		Rating:    rating,
+		CreatedAt: at,
+		UpdatedAt: at,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func insertPerson(t *testing.T, repo repository.DatabaseRepo, name string) int {
+	t.Helper()
+
+	id, err := repo.InsertPerson(models.Person{Name: name, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func insertCredit(t *testing.T, repo repository.DatabaseRepo, credit models.Credit) int {
+	t.Helper()
+
+	id, err := repo.InsertCredit(credit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func insertUserToken(t *testing.T, repo repository.DatabaseRepo, userId int, hash, scope string, ttl time.Duration) {
+	t.Helper()
+
+	err := repo.InsertUserToken(models.UserToken{
+		UserId:    userId,
+		TokenHash: hash,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func insertRefreshToken(t *testing.T, repo repository.DatabaseRepo, userId int, hash, familyId string) {
+	t.Helper()
+
+	err := repo.InsertRefreshToken(models.RefreshToken{
+		UserId:    userId,
+		TokenHash: hash,
+		FamilyId:  familyId,
+		ExpiresAt: time.Now().Add(time.Hour),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertRevoked(t *testing.T, repo repository.DatabaseRepo, want map[string]bool) {
+	t.Helper()
+
+	for hash, revoked := range want {
+		token, err := repo.GetRefreshTokenByHash(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (token.RevokedAt != nil) != revoked {
+			t.Errorf("token %s: revoked = %v, want %v", hash, token.RevokedAt != nil, revoked)
+		}
+	}
+}
+
+func assertList(t *testing.T, repo repository.DatabaseRepo, userId int, list string, want ...string) {
+	t.Helper()
+
+	entries, err := repo.ListEntries(userId, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for i, e := range entries {
+		got = append(got, e.Title)
+		if i > 0 && e.Position <= entries[i-1].Position {
+			t.Errorf("%s positions are not increasing: %d after %d", list, e.Position, entries[i-1].Position)
+		}
+	}
+	assertStrings(t, list, got, want)
+}
+
+func assertTitles(t *testing.T, movies []*models.Movie, want ...string) {
+	t.Helper()
+
+	var got []string
+	for _, m := range movies {
+		got = append(got, m.Title)
+	}
+	assertStrings(t, "titles", got, want)
+}
+
+func assertGenreNames(t *testing.T, genres []*models.Genre, want ...string) {
+	t.Helper()
+
+	var got []string
+	for _, g := range genres {
+		got = append(got, g.Genre)
+	}
+	assertStrings(t, "genres", got, want)
+}
+
+func searchTitles(results []*models.MovieSearchResult) []string {
+	var titles []string
+	for _, r := range results {
+		titles = append(titles, r.Title)
+	}
+
+	return titles
+}
+
+func assertStrings(t *testing.T, what string, got, want []string) {
+	t.Helper()
+
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("%s = %q, want %q", what, got, want)
+	}
+}
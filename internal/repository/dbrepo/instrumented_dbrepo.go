@@ -0,0 +1,285 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"time"
+)
+
+// InstrumentedDbRepo reports how long every method of the repository it
+// wraps takes, e.g. to export query latencies as metrics
+type InstrumentedDbRepo struct {
+	repository.DatabaseRepo
+
+	// Observe is called after every method with its name and duration
+	Observe func(method string, took time.Duration)
+}
+
+func (r *InstrumentedDbRepo) observe(method string, start time.Time) {
+	r.Observe(method, time.Since(start))
+}
+
+// WithContext keeps the repository bound to ctx instrumented
+func (r *InstrumentedDbRepo) WithContext(ctx context.Context) repository.DatabaseRepo {
+	return &InstrumentedDbRepo{DatabaseRepo: r.DatabaseRepo.WithContext(ctx), Observe: r.Observe}
+}
+
+// WithTx instruments the repository fn is given, and reports the whole
+// transaction as WithTx
+func (r *InstrumentedDbRepo) WithTx(fn func(repo repository.DatabaseRepo) error) error {
+	defer r.observe("WithTx", time.Now())
+	return r.DatabaseRepo.WithTx(func(repo repository.DatabaseRepo) error {
+		return fn(&InstrumentedDbRepo{DatabaseRepo: repo, Observe: r.Observe})
+	})
+}
+
+func (r *InstrumentedDbRepo) AllMovies(genre ...int) ([]*models.Movie, error) {
+	defer r.observe("AllMovies", time.Now())
+	return r.DatabaseRepo.AllMovies(genre...)
+}
+
+func (r *InstrumentedDbRepo) FilterMovies(q models.MovieQuery) (*models.MoviePage, error) {
+	defer r.observe("FilterMovies", time.Now())
+	return r.DatabaseRepo.FilterMovies(q)
+}
+
+func (r *InstrumentedDbRepo) SearchMovies(search string, limit int) ([]*models.MovieSearchResult, error) {
+	defer r.observe("SearchMovies", time.Now())
+	return r.DatabaseRepo.SearchMovies(search, limit)
+}
+
+func (r *InstrumentedDbRepo) OneMovie(id int) (*models.Movie, error) {
+	defer r.observe("OneMovie", time.Now())
+	return r.DatabaseRepo.OneMovie(id)
+}
+
+func (r *InstrumentedDbRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error) {
+	defer r.observe("OneMovieForEdit", time.Now())
+	return r.DatabaseRepo.OneMovieForEdit(id)
+}
+
+func (r *InstrumentedDbRepo) InsertMovie(movie models.Movie) (int, error) {
+	defer r.observe("InsertMovie", time.Now())
+	return r.DatabaseRepo.InsertMovie(movie)
+}
+
+func (r *InstrumentedDbRepo) UpdateMovie(movie models.Movie) error {
+	defer r.observe("UpdateMovie", time.Now())
+	return r.DatabaseRepo.UpdateMovie(movie)
+}
+
+func (r *InstrumentedDbRepo) UpdateMovieGenres(id int, genreIds []int) error {
+	defer r.observe("UpdateMovieGenres", time.Now())
+	return r.DatabaseRepo.UpdateMovieGenres(id, genreIds)
+}
+
+func (r *InstrumentedDbRepo) DeleteMovie(id, version int) error {
+	defer r.observe("DeleteMovie", time.Now())
+	return r.DatabaseRepo.DeleteMovie(id, version)
+}
+
+func (r *InstrumentedDbRepo) AllGenres() ([]*models.Genre, error) {
+	defer r.observe("AllGenres", time.Now())
+	return r.DatabaseRepo.AllGenres()
+}
+
+func (r *InstrumentedDbRepo) OneGenre(id int) (*models.Genre, error) {
+	defer r.observe("OneGenre", time.Now())
+	return r.DatabaseRepo.OneGenre(id)
+}
+
+func (r *InstrumentedDbRepo) InsertGenre(genre models.Genre) (int, error) {
+	defer r.observe("InsertGenre", time.Now())
+	return r.DatabaseRepo.InsertGenre(genre)
+}
+
+func (r *InstrumentedDbRepo) UpdateGenre(genre models.Genre) error {
+	defer r.observe("UpdateGenre", time.Now())
+	return r.DatabaseRepo.UpdateGenre(genre)
+}
+
+func (r *InstrumentedDbRepo) DeleteGenre(id int) error {
+	defer r.observe("DeleteGenre", time.Now())
+	return r.DatabaseRepo.DeleteGenre(id)
+}
+
+func (r *InstrumentedDbRepo) MergeGenres(fromId, intoId int) error {
+	defer r.observe("MergeGenres", time.Now())
+	return r.DatabaseRepo.MergeGenres(fromId, intoId)
+}
+
+func (r *InstrumentedDbRepo) GenresForMovies(movieIds []int) (map[int][]*models.Genre, error) {
+	defer r.observe("GenresForMovies", time.Now())
+	return r.DatabaseRepo.GenresForMovies(movieIds)
+}
+
+func (r *InstrumentedDbRepo) MoviesForGenres(genreIds []int, page, limit int) (map[int][]*models.Movie, error) {
+	defer r.observe("MoviesForGenres", time.Now())
+	return r.DatabaseRepo.MoviesForGenres(genreIds, page, limit)
+}
+
+func (r *InstrumentedDbRepo) MovieRating(movieId int) (*models.RatingSummary, error) {
+	defer r.observe("MovieRating", time.Now())
+	return r.DatabaseRepo.MovieRating(movieId)
+}
+
+func (r *InstrumentedDbRepo) ReviewsForMovie(movieId, page, limit int) ([]*models.Review, int, error) {
+	defer r.observe("ReviewsForMovie", time.Now())
+	return r.DatabaseRepo.ReviewsForMovie(movieId, page, limit)
+}
+
+func (r *InstrumentedDbRepo) OneReview(id int) (*models.Review, error) {
+	defer r.observe("OneReview", time.Now())
+	return r.DatabaseRepo.OneReview(id)
+}
+
+func (r *InstrumentedDbRepo) InsertReview(review models.Review) (int, error) {
+	defer r.observe("InsertReview", time.Now())
+	return r.DatabaseRepo.InsertReview(review)
+}
+
+func (r *InstrumentedDbRepo) UpdateReview(review models.Review) error {
+	defer r.observe("UpdateReview", time.Now())
+	return r.DatabaseRepo.UpdateReview(review)
+}
+
+func (r *InstrumentedDbRepo) DeleteReview(id int) error {
+	defer r.observe("DeleteReview", time.Now())
+	return r.DatabaseRepo.DeleteReview(id)
+}
+
+func (r *InstrumentedDbRepo) AllPeople() ([]*models.Person, error) {
+	defer r.observe("AllPeople", time.Now())
+	return r.DatabaseRepo.AllPeople()
+}
+
+func (r *InstrumentedDbRepo) OnePerson(id int) (*models.Person, error) {
+	defer r.observe("OnePerson", time.Now())
+	return r.DatabaseRepo.OnePerson(id)
+}
+
+func (r *InstrumentedDbRepo) InsertPerson(person models.Person) (int, error) {
+	defer r.observe("InsertPerson", time.Now())
+	return r.DatabaseRepo.InsertPerson(person)
+}
+
+func (r *InstrumentedDbRepo) UpdatePerson(person models.Person) error {
+	defer r.observe("UpdatePerson", time.Now())
+	return r.DatabaseRepo.UpdatePerson(person)
+}
+
+func (r *InstrumentedDbRepo) DeletePerson(id int) error {
+	defer r.observe("DeletePerson", time.Now())
+	return r.DatabaseRepo.DeletePerson(id)
+}
+
+func (r *InstrumentedDbRepo) InsertCredit(credit models.Credit) (int, error) {
+	defer r.observe("InsertCredit", time.Now())
+	return r.DatabaseRepo.InsertCredit(credit)
+}
+
+func (r *InstrumentedDbRepo) UpdateCredit(credit models.Credit) (bool, error) {
+	defer r.observe("UpdateCredit", time.Now())
+	return r.DatabaseRepo.UpdateCredit(credit)
+}
+
+func (r *InstrumentedDbRepo) DeleteCredit(personId, creditId int) (bool, error) {
+	defer r.observe("DeleteCredit", time.Now())
+	return r.DatabaseRepo.DeleteCredit(personId, creditId)
+}
+
+func (r *InstrumentedDbRepo) ListEntries(userId int, list string) ([]*models.ListEntry, error) {
+	defer r.observe("ListEntries", time.Now())
+	return r.DatabaseRepo.ListEntries(userId, list)
+}
+
+func (r *InstrumentedDbRepo) AddListEntry(userId int, list string, movieId int) (bool, error) {
+	defer r.observe("AddListEntry", time.Now())
+	return r.DatabaseRepo.AddListEntry(userId, list, movieId)
+}
+
+func (r *InstrumentedDbRepo) RemoveListEntry(userId int, list string, movieId int) (bool, error) {
+	defer r.observe("RemoveListEntry", time.Now())
+	return r.DatabaseRepo.RemoveListEntry(userId, list, movieId)
+}
+
+func (r *InstrumentedDbRepo) ReorderList(userId int, list string, movieIds []int) error {
+	defer r.observe("ReorderList", time.Now())
+	return r.DatabaseRepo.ReorderList(userId, list, movieIds)
+}
+
+func (r *InstrumentedDbRepo) SetListEntryWatched(userId int, list string, movieId int, watchedAt *time.Time) (bool, error) {
+	defer r.observe("SetListEntryWatched", time.Now())
+	return r.DatabaseRepo.SetListEntryWatched(userId, list, movieId, watchedAt)
+}
+
+func (r *InstrumentedDbRepo) GetUserByEmail(email string) (*models.User, error) {
+	defer r.observe("GetUserByEmail", time.Now())
+	return r.DatabaseRepo.GetUserByEmail(email)
+}
+
+func (r *InstrumentedDbRepo) GetUserById(id int) (*models.User, error) {
+	defer r.observe("GetUserById", time.Now())
+	return r.DatabaseRepo.GetUserById(id)
+}
+
+func (r *InstrumentedDbRepo) InsertUser(user models.User) (int, error) {
+	defer r.observe("InsertUser", time.Now())
+	return r.DatabaseRepo.InsertUser(user)
+}
+
+func (r *InstrumentedDbRepo) VerifyUser(id int) error {
+	defer r.observe("VerifyUser", time.Now())
+	return r.DatabaseRepo.VerifyUser(id)
+}
+
+func (r *InstrumentedDbRepo) UpdateUserPassword(id int, hash string) error {
+	defer r.observe("UpdateUserPassword", time.Now())
+	return r.DatabaseRepo.UpdateUserPassword(id, hash)
+}
+
+func (r *InstrumentedDbRepo) UpdateUserRole(id int, role string) error {
+	defer r.observe("UpdateUserRole", time.Now())
+	return r.DatabaseRepo.UpdateUserRole(id, role)
+}
+
+func (r *InstrumentedDbRepo) InsertUserToken(token models.UserToken) error {
+	defer r.observe("InsertUserToken", time.Now())
+	return r.DatabaseRepo.InsertUserToken(token)
+}
+
+func (r *InstrumentedDbRepo) ConsumeUserToken(hash, scope string) (*models.UserToken, error) {
+	defer r.observe("ConsumeUserToken", time.Now())
+	return r.DatabaseRepo.ConsumeUserToken(hash, scope)
+}
+
+func (r *InstrumentedDbRepo) DeleteUserTokens(userId int, scope string) error {
+	defer r.observe("DeleteUserTokens", time.Now())
+	return r.DatabaseRepo.DeleteUserTokens(userId, scope)
+}
+
+func (r *InstrumentedDbRepo) InsertRefreshToken(token models.RefreshToken) error {
+	defer r.observe("InsertRefreshToken", time.Now())
+	return r.DatabaseRepo.InsertRefreshToken(token)
+}
+
+func (r *InstrumentedDbRepo) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	defer r.observe("GetRefreshTokenByHash", time.Now())
+	return r.DatabaseRepo.GetRefreshTokenByHash(hash)
+}
+
+func (r *InstrumentedDbRepo) RevokeRefreshToken(id int) (bool, error) {
+	defer r.observe("RevokeRefreshToken", time.Now())
+	return r.DatabaseRepo.RevokeRefreshToken(id)
+}
+
+func (r *InstrumentedDbRepo) RevokeRefreshTokenFamily(familyId string) error {
+	defer r.observe("RevokeRefreshTokenFamily", time.Now())
+	return r.DatabaseRepo.RevokeRefreshTokenFamily(familyId)
+}
+
+func (r *InstrumentedDbRepo) RevokeUserRefreshTokens(userId int) error {
+	defer r.observe("RevokeUserRefreshTokens", time.Now())
+	return r.DatabaseRepo.RevokeUserRefreshTokens(userId)
+}
@@ -0,0 +1,1489 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryDbRepo is an in-memory stand in for PostgresDbRepo. It is safe for
+// concurrent use and mirrors the behaviour of the Postgres queries, including
+// returning sql.ErrNoRows for missing rows.
+type MemoryDbRepo struct {
+	mu sync.RWMutex
+
+	memoryState
+}
+
+// memoryState is the data of a MemoryDbRepo, kept apart from its lock so that
+// WithTx can work on a copy of it
+type memoryState struct {
+	movies       map[int]models.Movie
+	genres       map[int]models.Genre
+	moviesGenres []movieGenre
+	users        map[int]models.User
+
+	reviews       map[int]models.Review
+	people        map[int]models.Person
+	credits       map[int]models.Credit
+	listEntries   []listEntry
+	refreshTokens map[int]models.RefreshToken
+	userTokens    map[int]models.UserToken
+
+	nextMovieId        int
+	nextGenreId        int
+	nextUserId         int
+	nextReviewId       int
+	nextPersonId       int
+	nextCreditId       int
+	nextRefreshTokenId int
+	nextUserTokenId    int
+}
+
+// movieGenre is a row in the movies_genres join table
+type movieGenre struct {
+	MovieId int
+	GenreId int
+}
+
+// listEntry is a row in the list_entries table
+type listEntry struct {
+	UserId    int
+	List      string
+	MovieId   int
+	Position  int
+	WatchedAt *time.Time
+	CreatedAt time.Time
+}
+
+func NewMemoryDbRepo() *MemoryDbRepo {
+	return &MemoryDbRepo{memoryState: memoryState{
+		movies:        make(map[int]models.Movie),
+		genres:        make(map[int]models.Genre),
+		users:         make(map[int]models.User),
+		reviews:       make(map[int]models.Review),
+		people:        make(map[int]models.Person),
+		credits:       make(map[int]models.Credit),
+		refreshTokens: make(map[int]models.RefreshToken),
+		userTokens:    make(map[int]models.UserToken),
+
+		nextMovieId:        1,
+		nextGenreId:        1,
+		nextUserId:         1,
+		nextReviewId:       1,
+		nextPersonId:       1,
+		nextCreditId:       1,
+		nextRefreshTokenId: 1,
+		nextUserTokenId:    1,
+	}}
+}
+
+// clone returns a copy of the state that can be changed without affecting s
+func (s memoryState) clone() memoryState {
+	c := s
+
+	c.movies = copyMap(s.movies)
+	c.genres = copyMap(s.genres)
+	c.users = copyMap(s.users)
+	c.reviews = copyMap(s.reviews)
+	c.people = copyMap(s.people)
+	c.credits = copyMap(s.credits)
+	c.refreshTokens = copyMap(s.refreshTokens)
+	c.userTokens = copyMap(s.userTokens)
+
+	// rows are removed by filtering in place, so the slices must not be shared
+	c.moviesGenres = append([]movieGenre(nil), s.moviesGenres...)
+	c.listEntries = append([]listEntry(nil), s.listEntries...)
+
+	return c
+}
+
+func copyMap[T any](m map[int]T) map[int]T {
+	c := make(map[int]T, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
+
+// Seed loads genres, movies (with their genres_array) and users from a JSON file
+func (r *MemoryDbRepo) Seed(path string) error {
+	fixture, err := readFixture(path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	for _, g := range fixture.Genres {
+		g.Id = r.assignId(g.Id, &r.nextGenreId)
+		g.Checked = false
+		g.CreatedAt, g.UpdatedAt = now, now
+		r.genres[g.Id] = g
+	}
+
+	for _, u := range fixture.Users {
+		u.Id = r.assignId(u.Id, &r.nextUserId)
+		if u.Role == "" {
+			u.Role = models.RoleViewer
+		}
+		// fixture users can log in straight away
+		u.EmailVerifiedAt = &now
+		u.CreatedAt, u.UpdatedAt = now, now
+		r.users[u.Id] = u
+	}
+
+	for _, m := range fixture.Movies {
+		genreIds := m.GenresArray
+		m.Id = r.assignId(m.Id, &r.nextMovieId)
+		m.Genres = nil
+		m.GenresArray = nil
+		m.Version = 1
+		m.CreatedAt, m.UpdatedAt = now, now
+		r.movies[m.Id] = m
+
+		err = r.setMovieGenres(m.Id, genreIds)
+		if err != nil {
+			return fmt.Errorf("movie %d: %w", m.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// assignId returns id, or the next value of the sequence if id is zero, and
+// keeps the sequence ahead of any explicitly set ids
+func (r *MemoryDbRepo) assignId(id int, next *int) int {
+	if id == 0 {
+		id = *next
+	}
+
+	if id >= *next {
+		*next = id + 1
+	}
+
+	return id
+}
+
+func (r *MemoryDbRepo) Connection() *sql.DB {
+	return nil
+}
+
+// WithContext returns the repository itself; in-memory operations don't
+// block, so there is nothing to cancel
+func (r *MemoryDbRepo) WithContext(ctx context.Context) repository.DatabaseRepo {
+	return r
+}
+
+// WithTx runs fn on a copy of the data, which replaces the data only if fn
+// returns nil. Other callers wait until fn returns.
+func (r *MemoryDbRepo) WithTx(fn func(repo repository.DatabaseRepo) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx := &MemoryDbRepo{memoryState: r.memoryState.clone()}
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	r.memoryState = tx.memoryState
+	return nil
+}
+
+func (r *MemoryDbRepo) AllMovies(genre ...int) ([]*models.Movie, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var movies []*models.Movie
+
+	for _, m := range r.movies {
+		if len(genre) > 0 && !r.movieHasGenre(m.Id, genre[0]) {
+			continue
+		}
+
+		movie := m
+		movies = append(movies, &movie)
+	}
+
+	sort.Slice(movies, func(i, j int) bool {
+		return movies[i].Title < movies[j].Title
+	})
+
+	return movies, nil
+}
+
+func (r *MemoryDbRepo) FilterMovies(q models.MovieQuery) (*models.MoviePage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*models.Movie
+
+	for _, m := range r.movies {
+		if r.movieMatches(m, q) {
+			movie := m
+			matches = append(matches, &movie)
+		}
+	}
+
+	total := len(matches)
+
+	// a "before" cursor walks the listing backwards, and is flipped back afterwards
+	desc := q.Desc
+	if q.Cursor != nil && q.Cursor.Before {
+		desc = !desc
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		c := compareMovies(q.Sort, matches[i], matches[j])
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	if q.Cursor != nil {
+		value, err := q.CursorValue()
+		if err != nil {
+			return nil, err
+		}
+
+		var after []*models.Movie
+		for _, m := range matches {
+			c := compareToCursor(q.Sort, m, value, q.Cursor.Id)
+			if (!desc && c > 0) || (desc && c < 0) {
+				after = append(after, m)
+			}
+		}
+		matches = after
+	}
+
+	// keep one extra row to find out whether there is another page
+	start := q.Offset()
+	if start > len(matches) {
+		start = len(matches)
+	}
+	end := start + q.Limit + 1
+	if end > len(matches) {
+		end = len(matches)
+	}
+
+	return newMoviePage(q, matches[start:end], total), nil
+}
+
+func (r *MemoryDbRepo) movieMatches(m models.Movie, q models.MovieQuery) bool {
+	if q.TitleContains != "" && !strings.Contains(strings.ToLower(m.Title), strings.ToLower(q.TitleContains)) {
+		return false
+	}
+
+	if len(q.Ratings) > 0 {
+		found := false
+		for _, rating := range q.Ratings {
+			if m.MpaaRating == rating {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if q.YearFrom > 0 && m.ReleaseDate.Year() < q.YearFrom {
+		return false
+	}
+	if q.YearTo > 0 && m.ReleaseDate.Year() > q.YearTo {
+		return false
+	}
+
+	if q.RunTimeMin > 0 && m.RunTime < q.RunTimeMin {
+		return false
+	}
+	if q.RunTimeMax > 0 && m.RunTime > q.RunTimeMax {
+		return false
+	}
+
+	if len(q.Genres) > 0 {
+		matched := 0
+		for _, genreId := range q.Genres {
+			if r.movieHasGenre(m.Id, genreId) {
+				matched++
+			}
+		}
+
+		if q.MatchAllGenres && matched < len(q.Genres) {
+			return false
+		}
+		if matched == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compareMovies orders two movies by a sort column, then by id
+func compareMovies(column string, a, b *models.Movie) int {
+	var value any
+	switch column {
+	case models.SortReleaseDate:
+		value = b.ReleaseDate
+	case models.SortRunTime:
+		value = b.RunTime
+	case models.SortCreatedAt:
+		value = b.CreatedAt
+	default:
+		value = b.Title
+	}
+
+	return compareToCursor(column, a, value, b.Id)
+}
+
+// compareToCursor orders a movie against a sort value and id, as the
+// Postgres row comparison (column, id) does
+func compareToCursor(column string, m *models.Movie, value any, id int) int {
+	c := 0
+
+	switch column {
+	case models.SortReleaseDate:
+		c = compareTimes(m.ReleaseDate, value.(time.Time))
+	case models.SortRunTime:
+		c = m.RunTime - value.(int)
+	case models.SortCreatedAt:
+		c = compareTimes(m.CreatedAt, value.(time.Time))
+	default:
+		c = strings.Compare(m.Title, value.(string))
+	}
+
+	if c != 0 {
+		return c
+	}
+
+	return m.Id - id
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (r *MemoryDbRepo) SearchMovies(search string, limit int) ([]*models.MovieSearchResult, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	terms := searchTerms(search)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	joined := strings.Join(terms, " ")
+
+	var results []*models.MovieSearchResult
+
+	for _, m := range r.movies {
+		titleHits := matchingWords(m.Title, terms)
+		descriptionHits := matchingWords(m.Description, terms)
+		sim := similarity(m.Title, joined)
+
+		// every term has to prefix a word somewhere, unless the title is close enough
+		fullText := true
+		for _, term := range terms {
+			if titleHits[term] == 0 && descriptionHits[term] == 0 {
+				fullText = false
+				break
+			}
+		}
+		if !fullText && sim < trigramThreshold {
+			continue
+		}
+
+		rank := sim
+		for _, term := range terms {
+			rank += float64(titleHits[term]) + 0.4*float64(descriptionHits[term])
+		}
+
+		result := models.MovieSearchResult{
+			Movie:          m,
+			Rank:           rank,
+			TitleHighlight: highlight(m.Title, terms, 0),
+			Snippet:        highlight(m.Description, terms, 20),
+		}
+		results = append(results, &result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Title < results[j].Title
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+func (r *MemoryDbRepo) OneMovie(id int) (*models.Movie, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.movies[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	movie := m
+	movie.Genres = r.genresForMovie(id)
+	movie.Cast = r.castForMovie(id)
+
+	return &movie, nil
+}
+
+func (r *MemoryDbRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.movies[id]
+	if !ok {
+		return nil, nil, sql.ErrNoRows
+	}
+
+	movie := m
+	movie.Genres = r.genresForMovie(id)
+
+	var genresArray []int
+	for _, g := range movie.Genres {
+		genresArray = append(genresArray, g.Id)
+	}
+	movie.GenresArray = genresArray
+
+	// all genres only carry id and name, as in the Postgres query
+	var allGenres []*models.Genre
+	for _, g := range r.sortedGenres() {
+		allGenres = append(allGenres, &models.Genre{
+			Id:    g.Id,
+			Genre: g.Genre,
+		})
+	}
+
+	return &movie, allGenres, nil
+}
+
+func (r *MemoryDbRepo) AllGenres() ([]*models.Genre, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[int]int)
+	for _, mg := range r.moviesGenres {
+		counts[mg.GenreId]++
+	}
+
+	genres := r.sortedGenres()
+	for _, g := range genres {
+		count := counts[g.Id]
+		g.MovieCount = &count
+	}
+
+	return genres, nil
+}
+
+func (r *MemoryDbRepo) InsertGenre(genre models.Genre) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.genreNameTaken(genre.Genre, 0) {
+		return 0, models.ErrDuplicateGenre
+	}
+
+	genre.Id = r.assignId(0, &r.nextGenreId)
+	genre.MovieCount = nil
+	r.genres[genre.Id] = genre
+
+	return genre.Id, nil
+}
+
+func (r *MemoryDbRepo) UpdateGenre(genre models.Genre) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.genres[genre.Id]
+	if !ok {
+		return nil
+	}
+
+	if r.genreNameTaken(genre.Genre, genre.Id) {
+		return models.ErrDuplicateGenre
+	}
+
+	existing.Genre = genre.Genre
+	existing.UpdatedAt = genre.UpdatedAt
+	r.genres[genre.Id] = existing
+
+	return nil
+}
+
+func (r *MemoryDbRepo) DeleteGenre(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bumpGenreMovies(id)
+	r.deleteGenre(id)
+
+	return nil
+}
+
+func (r *MemoryDbRepo) MergeGenres(fromId, intoId int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, fromOk := r.genres[fromId]
+	_, intoOk := r.genres[intoId]
+	if !fromOk || !intoOk {
+		return sql.ErrNoRows
+	}
+
+	r.bumpGenreMovies(fromId)
+
+	for i, mg := range r.moviesGenres {
+		if mg.GenreId == fromId && !r.movieHasGenre(mg.MovieId, intoId) {
+			r.moviesGenres[i].GenreId = intoId
+		}
+	}
+
+	r.deleteGenre(fromId)
+
+	return nil
+}
+
+// deleteGenre removes a genre and, as the foreign key does, its movies_genres rows
+func (r *MemoryDbRepo) deleteGenre(id int) {
+	delete(r.genres, id)
+
+	kept := r.moviesGenres[:0]
+	for _, mg := range r.moviesGenres {
+		if mg.GenreId != id {
+			kept = append(kept, mg)
+		}
+	}
+	r.moviesGenres = kept
+}
+
+// bumpGenreMovies increments the version of every movie of a genre whose
+// genres are about to change
+func (r *MemoryDbRepo) bumpGenreMovies(genreId int) {
+	for _, mg := range r.moviesGenres {
+		if mg.GenreId != genreId {
+			continue
+		}
+
+		if movie, ok := r.movies[mg.MovieId]; ok {
+			movie.Version++
+			r.movies[mg.MovieId] = movie
+		}
+	}
+}
+
+// genreNameTaken reports whether a genre other than id has the name
+func (r *MemoryDbRepo) genreNameTaken(name string, id int) bool {
+	for _, g := range r.genres {
+		if g.Genre == name && g.Id != id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *MemoryDbRepo) OneGenre(id int) (*models.Genre, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	g, ok := r.genres[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return &g, nil
+}
+
+func (r *MemoryDbRepo) GenresForMovies(movieIds []int) (map[int][]*models.Genre, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	genres := make(map[int][]*models.Genre)
+	for _, id := range movieIds {
+		if found := r.genresForMovie(id); len(found) > 0 {
+			genres[id] = found
+		}
+	}
+
+	return genres, nil
+}
+
+func (r *MemoryDbRepo) MoviesForGenres(genreIds []int, page, limit int) (map[int][]*models.Movie, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	movies := make(map[int][]*models.Movie)
+	for _, genreId := range genreIds {
+		var found []*models.Movie
+		for _, m := range r.movies {
+			if r.movieHasGenre(m.Id, genreId) {
+				movie := m
+				found = append(found, &movie)
+			}
+		}
+
+		sort.Slice(found, func(i, j int) bool {
+			return compareMovies(models.SortTitle, found[i], found[j]) < 0
+		})
+
+		start := (page - 1) * limit
+		if start >= len(found) {
+			continue
+		}
+		end := start + limit
+		if end > len(found) {
+			end = len(found)
+		}
+
+		movies[genreId] = found[start:end]
+	}
+
+	return movies, nil
+}
+
+func (r *MemoryDbRepo) MovieRating(movieId int) (*models.RatingSummary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var summary models.RatingSummary
+	sum := 0
+	for _, review := range r.reviews {
+		if review.MovieId == movieId {
+			sum += review.Rating
+			summary.Count++
+		}
+	}
+
+	if summary.Count > 0 {
+		summary.Average = float64(sum) / float64(summary.Count)
+	}
+
+	return &summary, nil
+}
+
+func (r *MemoryDbRepo) ReviewsForMovie(movieId, page, limit int) ([]*models.Review, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found []*models.Review
+	for _, review := range r.reviews {
+		if review.MovieId == movieId {
+			found = append(found, r.withAuthor(review))
+		}
+	}
+
+	// newest first
+	sort.Slice(found, func(i, j int) bool {
+		if c := compareTimes(found[i].CreatedAt, found[j].CreatedAt); c != 0 {
+			return c > 0
+		}
+		return found[i].Id > found[j].Id
+	})
+
+	start := (page - 1) * limit
+	if start >= len(found) {
+		return nil, len(found), nil
+	}
+	end := start + limit
+	if end > len(found) {
+		end = len(found)
+	}
+
+	return found[start:end], len(found), nil
+}
+
+func (r *MemoryDbRepo) OneReview(id int) (*models.Review, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	review, ok := r.reviews[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return r.withAuthor(review), nil
+}
+
+func (r *MemoryDbRepo) InsertReview(review models.Review) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.movies[review.MovieId]; !ok {
+		return 0, fmt.Errorf("movie %d does not exist", review.MovieId)
+	}
+	if _, ok := r.users[review.UserId]; !ok {
+		return 0, fmt.Errorf("user %d does not exist", review.UserId)
+	}
+
+	for _, rv := range r.reviews {
+		if rv.MovieId == review.MovieId && rv.UserId == review.UserId {
+			return 0, models.ErrDuplicateReview
+		}
+	}
+
+	review.Id = r.assignId(0, &r.nextReviewId)
+	review.Author = ""
+	r.reviews[review.Id] = review
+
+	return review.Id, nil
+}
+
+func (r *MemoryDbRepo) UpdateReview(review models.Review) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.reviews[review.Id]
+	if !ok {
+		return nil
+	}
+
+	existing.Rating = review.Rating
+	existing.Body = review.Body
+	existing.UpdatedAt = review.UpdatedAt
+	r.reviews[review.Id] = existing
+
+	return nil
+}
+
+func (r *MemoryDbRepo) DeleteReview(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.reviews, id)
+
+	return nil
+}
+
+// withAuthor returns a copy of a review with the name of its author, as
+// the Postgres queries join it in
+func (r *MemoryDbRepo) withAuthor(review models.Review) *models.Review {
+	review.Author = r.users[review.UserId].FirstName
+	return &review
+}
+
+func (r *MemoryDbRepo) AllPeople() ([]*models.Person, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var people []*models.Person
+	for _, p := range r.people {
+		person := p
+		people = append(people, &person)
+	}
+
+	sort.Slice(people, func(i, j int) bool {
+		if people[i].Name != people[j].Name {
+			return people[i].Name < people[j].Name
+		}
+		return people[i].Id < people[j].Id
+	})
+
+	return people, nil
+}
+
+func (r *MemoryDbRepo) OnePerson(id int) (*models.Person, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.people[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	person := p
+	for _, c := range r.credits {
+		if c.PersonId != id {
+			continue
+		}
+
+		credit := c
+		movie := r.movies[c.MovieId]
+		credit.Movie = &movie
+		person.Credits = append(person.Credits, &credit)
+	}
+
+	// newest movie first
+	sort.Slice(person.Credits, func(i, j int) bool {
+		a, b := person.Credits[i], person.Credits[j]
+		if c := compareTimes(a.Movie.ReleaseDate, b.Movie.ReleaseDate); c != 0 {
+			return c > 0
+		}
+		if a.Movie.Title != b.Movie.Title {
+			return a.Movie.Title < b.Movie.Title
+		}
+		return a.Billing < b.Billing
+	})
+
+	return &person, nil
+}
+
+func (r *MemoryDbRepo) InsertPerson(person models.Person) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	person.Id = r.assignId(0, &r.nextPersonId)
+	person.Credits = nil
+	r.people[person.Id] = person
+
+	return person.Id, nil
+}
+
+func (r *MemoryDbRepo) UpdatePerson(person models.Person) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.people[person.Id]
+	if !ok {
+		return nil
+	}
+
+	existing.Name = person.Name
+	existing.Birthday = person.Birthday
+	existing.Biography = person.Biography
+	existing.Image = person.Image
+	existing.UpdatedAt = person.UpdatedAt
+	r.people[person.Id] = existing
+
+	return nil
+}
+
+func (r *MemoryDbRepo) DeletePerson(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.people, id)
+
+	// cascade to credits, as the foreign key does
+	for creditId, c := range r.credits {
+		if c.PersonId == id {
+			delete(r.credits, creditId)
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryDbRepo) InsertCredit(credit models.Credit) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.movies[credit.MovieId]; !ok {
+		return 0, fmt.Errorf("movie %d does not exist", credit.MovieId)
+	}
+	if _, ok := r.people[credit.PersonId]; !ok {
+		return 0, fmt.Errorf("person %d does not exist", credit.PersonId)
+	}
+
+	credit.Id = r.assignId(0, &r.nextCreditId)
+	credit.Person = nil
+	credit.Movie = nil
+	r.credits[credit.Id] = credit
+
+	return credit.Id, nil
+}
+
+func (r *MemoryDbRepo) UpdateCredit(credit models.Credit) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.credits[credit.Id]
+	if !ok || existing.PersonId != credit.PersonId {
+		return false, nil
+	}
+
+	if _, ok := r.movies[credit.MovieId]; !ok {
+		return false, fmt.Errorf("movie %d does not exist", credit.MovieId)
+	}
+
+	existing.MovieId = credit.MovieId
+	existing.Type = credit.Type
+	existing.Character = credit.Character
+	existing.Billing = credit.Billing
+	r.credits[credit.Id] = existing
+
+	return true, nil
+}
+
+func (r *MemoryDbRepo) DeleteCredit(personId, creditId int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.credits[creditId]
+	if !ok || existing.PersonId != personId {
+		return false, nil
+	}
+
+	delete(r.credits, creditId)
+
+	return true, nil
+}
+
+// castForMovie returns the top billed cast of a movie, with their people
+func (r *MemoryDbRepo) castForMovie(movieId int) []*models.Credit {
+	var cast []*models.Credit
+	for _, c := range r.credits {
+		if c.MovieId != movieId || c.Type != models.CreditCast {
+			continue
+		}
+
+		credit := c
+		person := r.people[c.PersonId]
+		credit.Person = &person
+		cast = append(cast, &credit)
+	}
+
+	sort.Slice(cast, func(i, j int) bool {
+		if cast[i].Billing != cast[j].Billing {
+			return cast[i].Billing < cast[j].Billing
+		}
+		return cast[i].Id < cast[j].Id
+	})
+
+	if len(cast) > models.TopBilledCast {
+		cast = cast[:models.TopBilledCast]
+	}
+
+	return cast
+}
+
+func (r *MemoryDbRepo) ListEntries(userId int, list string) ([]*models.ListEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var entries []*models.ListEntry
+	for _, e := range r.listEntries {
+		if e.UserId != userId || e.List != list {
+			continue
+		}
+
+		entries = append(entries, &models.ListEntry{
+			Movie:     r.movies[e.MovieId],
+			Position:  e.Position,
+			WatchedAt: e.WatchedAt,
+			AddedAt:   e.CreatedAt,
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Position < entries[j].Position
+	})
+
+	return entries, nil
+}
+
+func (r *MemoryDbRepo) AddListEntry(userId int, list string, movieId int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.movies[movieId]; !ok {
+		return false, fmt.Errorf("movie %d does not exist", movieId)
+	}
+
+	position := 0
+	for _, e := range r.listEntries {
+		if e.UserId != userId || e.List != list {
+			continue
+		}
+		if e.MovieId == movieId {
+			return false, nil
+		}
+		if e.Position > position {
+			position = e.Position
+		}
+	}
+
+	r.listEntries = append(r.listEntries, listEntry{
+		UserId:    userId,
+		List:      list,
+		MovieId:   movieId,
+		Position:  position + 1,
+		CreatedAt: time.Now(),
+	})
+
+	return true, nil
+}
+
+func (r *MemoryDbRepo) RemoveListEntry(userId int, list string, movieId int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	before := len(r.listEntries)
+	r.removeListEntries(func(e listEntry) bool {
+		return e.UserId == userId && e.List == list && e.MovieId == movieId
+	})
+
+	return len(r.listEntries) < before, nil
+}
+
+func (r *MemoryDbRepo) ReorderList(userId int, list string, movieIds []int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := make(map[int]bool)
+	for _, e := range r.listEntries {
+		if e.UserId == userId && e.List == list {
+			current[e.MovieId] = true
+		}
+	}
+
+	if !sameIds(current, movieIds) {
+		return models.ErrInvalidOrder
+	}
+
+	positions := make(map[int]int)
+	for i, movieId := range movieIds {
+		positions[movieId] = i + 1
+	}
+
+	for i, e := range r.listEntries {
+		if e.UserId == userId && e.List == list {
+			r.listEntries[i].Position = positions[e.MovieId]
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryDbRepo) SetListEntryWatched(userId int, list string, movieId int, watchedAt *time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, e := range r.listEntries {
+		if e.UserId == userId && e.List == list && e.MovieId == movieId {
+			r.listEntries[i].WatchedAt = watchedAt
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// removeListEntries deletes the list entries matching a condition
+func (r *MemoryDbRepo) removeListEntries(match func(e listEntry) bool) {
+	kept := r.listEntries[:0]
+	for _, e := range r.listEntries {
+		if !match(e) {
+			kept = append(kept, e)
+		}
+	}
+	r.listEntries = kept
+}
+
+func (r *MemoryDbRepo) GetUserByEmail(email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// emails are matched in lower case, like the unique index on them
+	for _, u := range r.users {
+		if strings.ToLower(u.Email) == strings.ToLower(email) {
+			user := u
+			return &user, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryDbRepo) GetUserById(id int) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return &u, nil
+}
+
+func (r *MemoryDbRepo) InsertUser(user models.User) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if strings.ToLower(u.Email) == strings.ToLower(user.Email) {
+			return 0, models.ErrDuplicateEmail
+		}
+	}
+
+	user.Id = r.assignId(0, &r.nextUserId)
+	r.users[user.Id] = user
+
+	return user.Id, nil
+}
+
+func (r *MemoryDbRepo) VerifyUser(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	user.UpdatedAt = now
+	r.users[id] = user
+
+	return nil
+}
+
+func (r *MemoryDbRepo) UpdateUserPassword(id int, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil
+	}
+
+	user.Password = hash
+	user.UpdatedAt = time.Now()
+	r.users[id] = user
+
+	return nil
+}
+
+func (r *MemoryDbRepo) UpdateUserRole(id int, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil
+	}
+
+	user.Role = role
+	user.UpdatedAt = time.Now()
+	r.users[id] = user
+
+	return nil
+}
+
+func (r *MemoryDbRepo) InsertUserToken(token models.UserToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[token.UserId]; !ok {
+		return fmt.Errorf("user %d does not exist", token.UserId)
+	}
+
+	for _, t := range r.userTokens {
+		if t.TokenHash == token.TokenHash {
+			return errors.New("duplicate user token")
+		}
+	}
+
+	token.Id = r.assignId(0, &r.nextUserTokenId)
+	r.userTokens[token.Id] = token
+
+	return nil
+}
+
+func (r *MemoryDbRepo) ConsumeUserToken(hash, scope string) (*models.UserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	for id, t := range r.userTokens {
+		if t.TokenHash != hash || t.Scope != scope || t.UsedAt != nil || !t.ExpiresAt.After(now) {
+			continue
+		}
+
+		t.UsedAt = &now
+		r.userTokens[id] = t
+
+		return &t, nil
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryDbRepo) DeleteUserTokens(userId int, scope string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, t := range r.userTokens {
+		if t.UserId == userId && t.Scope == scope {
+			delete(r.userTokens, id)
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryDbRepo) InsertRefreshToken(token models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[token.UserId]; !ok {
+		return fmt.Errorf("user %d does not exist", token.UserId)
+	}
+
+	for _, t := range r.refreshTokens {
+		if t.TokenHash == token.TokenHash {
+			return errors.New("duplicate refresh token")
+		}
+	}
+
+	token.Id = r.assignId(0, &r.nextRefreshTokenId)
+	r.refreshTokens[token.Id] = token
+
+	return nil
+}
+
+func (r *MemoryDbRepo) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.refreshTokens {
+		if t.TokenHash == hash {
+			token := t
+			return &token, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (r *MemoryDbRepo) RevokeRefreshToken(id int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.refreshTokens[id]
+	if !ok || token.RevokedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	token.RevokedAt = &now
+	r.refreshTokens[id] = token
+
+	return true, nil
+}
+
+func (r *MemoryDbRepo) RevokeRefreshTokenFamily(familyId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, token := range r.refreshTokens {
+		if token.FamilyId == familyId && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			r.refreshTokens[id] = token
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryDbRepo) RevokeUserRefreshTokens(userId int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, token := range r.refreshTokens {
+		if token.UserId == userId && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			r.refreshTokens[id] = token
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryDbRepo) InsertMovie(movie models.Movie) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the id is generated, as with the identity column
+	movie.Id = r.assignId(0, &r.nextMovieId)
+	movie.Version = 1
+	movie.Genres = nil
+	movie.GenresArray = nil
+	r.movies[movie.Id] = movie
+
+	return movie.Id, nil
+}
+
+func (r *MemoryDbRepo) UpdateMovie(movie models.Movie) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.movies[movie.Id]
+	if !ok || existing.Version != movie.Version {
+		return models.ErrEditConflict
+	}
+
+	existing.Title = movie.Title
+	existing.Description = movie.Description
+	existing.ReleaseDate = movie.ReleaseDate
+	existing.RunTime = movie.RunTime
+	existing.MpaaRating = movie.MpaaRating
+	existing.UpdatedAt = movie.UpdatedAt
+	existing.Image = movie.Image
+	existing.Version++
+	r.movies[movie.Id] = existing
+
+	return nil
+}
+
+func (r *MemoryDbRepo) DeleteMovie(id, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	movie, ok := r.movies[id]
+	if !ok || movie.Version != version {
+		return models.ErrEditConflict
+	}
+
+	delete(r.movies, id)
+
+	// cascade to movies_genres, reviews, credits and list_entries, as the
+	// foreign keys do
+	r.removeMovieGenres(id)
+	for creditId, c := range r.credits {
+		if c.MovieId == id {
+			delete(r.credits, creditId)
+		}
+	}
+	r.removeListEntries(func(e listEntry) bool {
+		return e.MovieId == id
+	})
+	for reviewId, review := range r.reviews {
+		if review.MovieId == id {
+			delete(r.reviews, reviewId)
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryDbRepo) UpdateMovieGenres(id int, genreIds []int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.setMovieGenres(id, genreIds)
+	if err != nil {
+		return err
+	}
+
+	// the genres are part of the movie, so changing them is a new version
+	if movie, ok := r.movies[id]; ok {
+		movie.Version++
+		r.movies[id] = movie
+	}
+
+	return nil
+}
+
+// setMovieGenres replaces the genres of a movie. The ids are checked up front
+// so a foreign key failure leaves the existing rows in place.
+func (r *MemoryDbRepo) setMovieGenres(id int, genreIds []int) error {
+	if _, ok := r.movies[id]; !ok && len(genreIds) > 0 {
+		return fmt.Errorf("movie %d does not exist", id)
+	}
+
+	for _, n := range genreIds {
+		if _, ok := r.genres[n]; !ok {
+			return fmt.Errorf("genre %d does not exist", n)
+		}
+	}
+
+	r.removeMovieGenres(id)
+
+	for _, n := range genreIds {
+		r.moviesGenres = append(r.moviesGenres, movieGenre{MovieId: id, GenreId: n})
+	}
+
+	return nil
+}
+
+func (r *MemoryDbRepo) removeMovieGenres(id int) {
+	kept := r.moviesGenres[:0]
+	for _, mg := range r.moviesGenres {
+		if mg.MovieId != id {
+			kept = append(kept, mg)
+		}
+	}
+	r.moviesGenres = kept
+}
+
+func (r *MemoryDbRepo) movieHasGenre(movieId, genreId int) bool {
+	for _, mg := range r.moviesGenres {
+		if mg.MovieId == movieId && mg.GenreId == genreId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// genresForMovie returns the id and name of each genre of a movie, ordered by name
+func (r *MemoryDbRepo) genresForMovie(id int) []*models.Genre {
+	var genres []*models.Genre
+	for _, mg := range r.moviesGenres {
+		if mg.MovieId != id {
+			continue
+		}
+
+		g := r.genres[mg.GenreId]
+		genres = append(genres, &models.Genre{
+			Id:    g.Id,
+			Genre: g.Genre,
+		})
+	}
+
+	sort.SliceStable(genres, func(i, j int) bool {
+		return genres[i].Genre < genres[j].Genre
+	})
+
+	return genres
+}
+
+func (r *MemoryDbRepo) sortedGenres() []*models.Genre {
+	var genres []*models.Genre
+	for _, g := range r.genres {
+		genre := g
+		genres = append(genres, &genre)
+	}
+
+	sort.Slice(genres, func(i, j int) bool {
+		return genres[i].Genre < genres[j].Genre
+	})
+
+	return genres
+}
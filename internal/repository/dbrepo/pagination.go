@@ -0,0 +1,35 @@
+package dbrepo
+
+import "backend/internal/models"
+
+// newMoviePage builds a page from rows fetched with a limit of q.Limit+1,
+// where the extra row only signals that there is more to come
+func newMoviePage(q models.MovieQuery, movies []*models.Movie, total int) *models.MoviePage {
+	more := len(movies) > q.Limit
+	if more {
+		movies = movies[:q.Limit]
+	}
+
+	page := &models.MoviePage{
+		Movies: movies,
+		Total:  total,
+	}
+
+	switch {
+	case q.Cursor != nil && q.Cursor.Before:
+		// rows were read backwards from the cursor
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+		page.HasPrev = more
+		page.HasNext = true
+	case q.Cursor != nil:
+		page.HasNext = more
+		page.HasPrev = true
+	default:
+		page.HasNext = more
+		page.HasPrev = q.Page > 1
+	}
+
+	return page
+}
@@ -2,25 +2,94 @@ package dbrepo
 
 import (
 	"backend/internal/models"
+	"backend/internal/repository"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/jackc/pgconn"
 )
 
 type PostgresDbRepo struct {
 	Db *sql.DB
+
+	// ctx, when set by WithContext, bounds every query
+	ctx context.Context
+
+	// tx, when set by WithTx, runs every query in a transaction
+	tx *sql.Tx
 }
 
 const dbTimeout = time.Second * 3
 
+// queryer is what *sql.DB and *sql.Tx have in common
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 func (r *PostgresDbRepo) Connection() *sql.DB {
 	return r.Db
 }
 
+// conn returns the transaction queries run in, if there is one, or the pool
+func (r *PostgresDbRepo) conn() queryer {
+	if r.tx != nil {
+		return r.tx
+	}
+
+	return r.Db
+}
+
+// WithTx runs fn in a transaction. Everything fn does through the repository
+// it is given commits if fn returns nil, and rolls back otherwise. Calls
+// within a transaction join it.
+func (r *PostgresDbRepo) WithTx(fn func(repo repository.DatabaseRepo) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	tx, err := r.Db.BeginTx(r.parentContext(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	repo := *r
+	repo.tx = tx
+
+	err = fn(&repo)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// WithContext returns a copy of the repository whose queries are cancelled
+// along with ctx, e.g. when the client of a request goes away
+func (r *PostgresDbRepo) WithContext(ctx context.Context) repository.DatabaseRepo {
+	repo := *r
+	repo.ctx = ctx
+
+	return &repo
+}
+
+func (r *PostgresDbRepo) parentContext() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+
+	return r.ctx
+}
+
 // ...int means 0 or more ints, making it optional
 func (r *PostgresDbRepo) AllMovies(genre ...int) ([]*models.Movie, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
 	defer cancel()
 
 	where := ""
@@ -39,7 +108,7 @@ func (r *PostgresDbRepo) AllMovies(genre ...int) ([]*models.Movie, error) {
 		where,
 	)
 
-	rows, err := r.Db.QueryContext(ctx, query)
+	rows, err := r.conn().QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -70,13 +139,87 @@ func (r *PostgresDbRepo) AllMovies(genre ...int) ([]*models.Movie, error) {
 	return movies, nil
 }
 
+func (r *PostgresDbRepo) SearchMovies(search string, limit int) ([]*models.MovieSearchResult, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	terms := searchTerms(search)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	// full text matches on word prefixes, plus trigram matches on the title
+	// to catch typos. Title words weigh more than description words. The
+	// headlines mark matches with control characters, which are stripped
+	// from the text first, and only become tags after escaping.
+	query := `
+		WITH q AS (
+			SELECT to_tsquery('english', $2) AS ts
+		)
+		SELECT
+			m.id, m.title, m.release_date, m.runtime, m.mpaa_rating, m.description, coalesce(m.image, ''), m.created_at, m.updated_at,
+			ts_rank(m.search_vector, q.ts) + similarity(m.title, $1) AS rank,
+			ts_headline('english', translate(m.title, $4::text, ''), q.ts, 'HighlightAll=true, ' || $5::text),
+			ts_headline('english', translate(coalesce(m.description, ''), $4::text, ''), q.ts, 'MaxFragments=2, MaxWords=20, MinWords=5, ' || $5::text)
+		FROM
+			movies AS m, q
+		WHERE
+			m.search_vector @@ q.ts
+			OR m.title % $1
+		ORDER BY rank DESC, m.title
+		LIMIT $3
+		`
+
+	rows, err := r.conn().QueryContext(ctx, query,
+		strings.Join(terms, " "),
+		prefixQuery(terms),
+		limit,
+		headlineStart+headlineStop,
+		"StartSel="+headlineStart+", StopSel="+headlineStop,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.MovieSearchResult
+
+	for rows.Next() {
+		var result models.MovieSearchResult
+		err := rows.Scan(
+			&result.Id,
+			&result.Title,
+			&result.ReleaseDate,
+			&result.RunTime,
+			&result.MpaaRating,
+			&result.Description,
+			&result.Image,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.Rank,
+			&result.TitleHighlight,
+			&result.Snippet,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		result.TitleHighlight = headlineHtml(result.TitleHighlight)
+		result.Snippet = headlineHtml(result.Snippet)
+
+		results = append(results, &result)
+	}
+
+	return results, nil
+}
+
 func (r *PostgresDbRepo) OneMovie(id int) (*models.Movie, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
 	defer cancel()
 
 	query := `
 		SELECT
-			id, title, release_date, runtime, mpaa_rating, description, COALESCE(image, ''), created_at, updated_at
+			id, title, release_date, runtime, mpaa_rating, description, COALESCE(image, ''), version, created_at, updated_at
 		FROM
 			movies
 		WHERE
@@ -84,7 +227,7 @@ func (r *PostgresDbRepo) OneMovie(id int) (*models.Movie, error) {
 	`
 	var movie models.Movie
 
-	row := r.Db.QueryRowContext(ctx, query, id)
+	row := r.conn().QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
 		&movie.Id,
@@ -94,6 +237,7 @@ func (r *PostgresDbRepo) OneMovie(id int) (*models.Movie, error) {
 		&movie.MpaaRating,
 		&movie.Description,
 		&movie.Image,
+		&movie.Version,
 		&movie.CreatedAt,
 		&movie.UpdatedAt,
 	)
@@ -114,7 +258,7 @@ func (r *PostgresDbRepo) OneMovie(id int) (*models.Movie, error) {
 		ORDER BY g.genre
 	`
 
-	rows, err := r.Db.QueryContext(ctx, query, id)
+	rows, err := r.conn().QueryContext(ctx, query, id)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
@@ -136,16 +280,66 @@ func (r *PostgresDbRepo) OneMovie(id int) (*models.Movie, error) {
 
 	movie.Genres = genres
 
+	// get the top billed cast
+	query = `
+		SELECT
+			c.id, c.movie_id, c.person_id, c.type, c.character, c.billing,
+			p.id, p.name, p.birthday, p.biography, coalesce(p.image, ''), p.created_at, p.updated_at
+		FROM
+			credits AS c
+		JOIN people AS p on (c.person_id = p.id)
+		WHERE
+			c.movie_id = $1 AND c.type = $2
+		ORDER BY c.billing, c.id
+		LIMIT $3
+	`
+
+	castRows, err := r.conn().QueryContext(ctx, query, id, models.CreditCast, models.TopBilledCast)
+	if err != nil {
+		return nil, err
+	}
+	defer castRows.Close()
+
+	for castRows.Next() {
+		var credit models.Credit
+		var person models.Person
+		err := castRows.Scan(
+			&credit.Id,
+			&credit.MovieId,
+			&credit.PersonId,
+			&credit.Type,
+			&credit.Character,
+			&credit.Billing,
+			&person.Id,
+			&person.Name,
+			&person.Birthday,
+			&person.Biography,
+			&person.Image,
+			&person.CreatedAt,
+			&person.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credit.Person = &person
+		movie.Cast = append(movie.Cast, &credit)
+	}
+
+	if err = castRows.Err(); err != nil {
+		return nil, err
+	}
+
 	return &movie, nil
 }
 
 func (r *PostgresDbRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
 	defer cancel()
 
 	query := `
 		SELECT
-			id, title, release_date, runtime, mpaa_rating, description, COALESCE(image, ''), created_at, updated_at
+			id, title, release_date, runtime, mpaa_rating, description, COALESCE(image, ''), version, created_at, updated_at
 		FROM
 			movies
 		WHERE
@@ -153,7 +347,7 @@ func (r *PostgresDbRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 	`
 	var movie models.Movie
 
-	row := r.Db.QueryRowContext(ctx, query, id)
+	row := r.conn().QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
 		&movie.Id,
@@ -163,6 +357,7 @@ func (r *PostgresDbRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 		&movie.MpaaRating,
 		&movie.Description,
 		&movie.Image,
+		&movie.Version,
 		&movie.CreatedAt,
 		&movie.UpdatedAt,
 	)
@@ -183,7 +378,7 @@ func (r *PostgresDbRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 		ORDER BY g.genre
 	`
 
-	rows, err := r.Db.QueryContext(ctx, query, id)
+	rows, err := r.conn().QueryContext(ctx, query, id)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, nil, err
 	}
@@ -217,7 +412,7 @@ func (r *PostgresDbRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 		ORDER BY genre
 	`
 	var allGenres []*models.Genre
-	rows, err = r.Db.QueryContext(ctx, query)
+	rows, err = r.conn().QueryContext(ctx, query)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, nil, err
 	}
@@ -240,18 +435,19 @@ func (r *PostgresDbRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 }
 
 func (r *PostgresDbRepo) AllGenres() ([]*models.Genre, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
 	defer cancel()
 
-	// get all genres
+	// get all genres, with the number of movies in each
 	query := `
 		SELECT
-			id, genre, created_at, updated_at
-		FROM genres
-		ORDER BY genre
+			g.id, g.genre, g.created_at, g.updated_at,
+			(SELECT count(*) FROM movies_genres AS mg WHERE mg.genre_id = g.id)
+		FROM genres AS g
+		ORDER BY g.genre
 	`
 	var genres []*models.Genre
-	rows, err := r.Db.QueryContext(ctx, query)
+	rows, err := r.conn().QueryContext(ctx, query)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
@@ -259,197 +455,1646 @@ func (r *PostgresDbRepo) AllGenres() ([]*models.Genre, error) {
 
 	for rows.Next() {
 		var g models.Genre
+		var count int
 		err := rows.Scan(
 			&g.Id,
 			&g.Genre,
 			&g.CreatedAt,
 			&g.UpdatedAt,
+			&count,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		g.MovieCount = &count
 		genres = append(genres, &g)
 	}
 
 	return genres, nil
 }
 
-func (r *PostgresDbRepo) GetUserByEmail(email string) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (r *PostgresDbRepo) InsertGenre(genre models.Genre) (int, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
 	defer cancel()
 
-	query := `
-		SELECT
-			id, email, first_name, last_name, password, created_at, updated_at
-		FROM
-			users
-		WHERE
-			email = $1
-	`
-
-	var user models.User
+	stmt := `
+		INSERT INTO genres
+			(genre, created_at, updated_at)
+			VALUES ($1, $2, $3)
+			RETURNING id
+		`
 
-	row := r.Db.QueryRowContext(ctx, query, email)
+	var newId int
 
-	err := row.Scan(
-		&user.Id,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Password,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	err := r.conn().QueryRowContext(ctx, stmt, genre.Genre, genre.CreatedAt, genre.UpdatedAt).Scan(&newId)
 	if err != nil {
-		return nil, err
+		if isUniqueViolation(err) {
+			return 0, models.ErrDuplicateGenre
+		}
+		return 0, err
 	}
 
-	return &user, nil
+	return newId, nil
 }
 
-func (r *PostgresDbRepo) GetUserById(id int) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (r *PostgresDbRepo) UpdateGenre(genre models.Genre) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
 	defer cancel()
 
-	query := `
-		SELECT
-			id, email, first_name, last_name, password, created_at, updated_at
-		FROM
-			users
-		WHERE
-			id = $1
+	stmt := `
+		UPDATE genres SET
+			genre = $1,
+			updated_at = $2
+		WHERE id = $3
 	`
 
-	var user models.User
-
-	row := r.Db.QueryRowContext(ctx, query, id)
-
-	err := row.Scan(
-		&user.Id,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Password,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	_, err := r.conn().ExecContext(ctx, stmt, genre.Genre, genre.UpdatedAt, genre.Id)
 	if err != nil {
-		return nil, err
+		if isUniqueViolation(err) {
+			return models.ErrDuplicateGenre
+		}
+		return err
 	}
 
-	return &user, nil
+	return nil
 }
 
-func (r *PostgresDbRepo) InsertMovie(movie models.Movie) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// DeleteGenre removes a genre. Its movies_genres rows go with it, which
+// makes a new version of each of its movies.
+func (r *PostgresDbRepo) DeleteGenre(id int) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
 	defer cancel()
 
-	stmt := `
-		INSERT INTO movies
-			(title, description, release_date, runtime, mpaa_rating, created_at, updated_at, image)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-			RETURNING ID
-		`
+	return r.WithTx(func(repo repository.DatabaseRepo) error {
+		tx := repo.(*PostgresDbRepo).conn()
 
-	var newId int
+		err := bumpGenreMovies(ctx, tx, id)
+		if err != nil {
+			return err
+		}
 
-	err := r.Db.QueryRowContext(
-		ctx,
-		stmt,
-		movie.Title,
-		movie.Description,
-		movie.ReleaseDate,
-		movie.RunTime,
-		movie.MpaaRating,
-		movie.CreatedAt,
-		movie.UpdatedAt,
-		movie.Image,
-	).Scan(&newId)
+		_, err = tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1`, id)
+		return err
+	})
+}
 
-	if err != nil {
-		return 0, err
-	}
+// bumpGenreMovies increments the version of every movie of a genre whose
+// genres are about to change
+func bumpGenreMovies(ctx context.Context, tx queryer, genreId int) error {
+	stmt := `
+		UPDATE movies SET
+			version = version + 1
+		WHERE id IN (
+			SELECT movie_id FROM movies_genres WHERE genre_id = $1
+		)
+	`
 
-	return newId, nil
+	_, err := tx.ExecContext(ctx, stmt, genreId)
+	return err
 }
 
-func (r *PostgresDbRepo) UpdateMovie(movie models.Movie) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// MergeGenres moves every movie of one genre to another, then deletes the
+// first genre, in one transaction. Movies already in both keep one row.
+// A missing genre gives sql.ErrNoRows.
+func (r *PostgresDbRepo) MergeGenres(fromId, intoId int) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
 	defer cancel()
 
-	stmt := `
-		UPDATE movies SET
-			title = $1,
-			description = $2,
-			release_date = $3,
-			runtime = $4,
-			mpaa_rating = $5,
-			updated_at = $6,
-			image = $7
-		WHERE id = $8
-	`
+	return r.WithTx(func(repo repository.DatabaseRepo) error {
+		tx := repo.(*PostgresDbRepo).conn()
 
-	_, err := r.Db.ExecContext(
-		ctx,
-		stmt,
-		movie.Title,
-		movie.Description,
-		movie.ReleaseDate,
-		movie.RunTime,
-		movie.MpaaRating,
-		movie.UpdatedAt,
-		movie.Image,
-		movie.Id,
-	)
-	if err != nil {
-		return err
-	}
+		// lock both genres, so neither goes away during the merge
+		var found int
+		err := tx.QueryRowContext(ctx,
+			`SELECT count(*) FROM (SELECT id FROM genres WHERE id IN ($1, $2) FOR UPDATE) AS g`,
+			fromId, intoId,
+		).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if found != 2 {
+			return sql.ErrNoRows
+		}
 
-	return nil
+		// every movie of the merged genre changes genres: it moves to the
+		// other one, or loses its duplicate
+		err = bumpGenreMovies(ctx, tx, fromId)
+		if err != nil {
+			return err
+		}
+
+		stmt := `
+			UPDATE movies_genres SET
+				genre_id = $2
+			WHERE genre_id = $1 AND movie_id NOT IN (
+				SELECT movie_id FROM movies_genres WHERE genre_id = $2
+			)
+		`
+		_, err = tx.ExecContext(ctx, stmt, fromId, intoId)
+		if err != nil {
+			return err
+		}
+
+		// the rest are movies that were in both, and cascade away with the genre
+		_, err = tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1`, fromId)
+		return err
+	})
 }
 
-func (r *PostgresDbRepo) DeleteMovie(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (r *PostgresDbRepo) OneGenre(id int) (*models.Genre, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
 	defer cancel()
 
-	stmt := `
-		DELETE FROM movies
+	query := `
+		SELECT
+			id, genre, created_at, updated_at
+		FROM genres
 		WHERE id = $1
 	`
-	// genres are taken care of by the Postgres foreign key
-	_, err := r.Db.ExecContext(ctx, stmt, id)
+
+	var g models.Genre
+
+	row := r.conn().QueryRowContext(ctx, query, id)
+
+	err := row.Scan(
+		&g.Id,
+		&g.Genre,
+		&g.CreatedAt,
+		&g.UpdatedAt,
+	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &g, nil
 }
 
-func (r *PostgresDbRepo) UpdateMovieGenres(id int, genreIds []int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// GenresForMovies loads the genres of many movies in one query, keyed by
+// movie id and ordered by name
+func (r *PostgresDbRepo) GenresForMovies(movieIds []int) (map[int][]*models.Genre, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
 	defer cancel()
 
-	stmt := `
-		DELETE FROM movies_genres
-		WHERE movie_id = $1
-	`
+	genres := make(map[int][]*models.Genre)
+	if len(movieIds) == 0 {
+		return genres, nil
+	}
+
+	var args []any
+	query := fmt.Sprintf(`
+		SELECT
+			mg.movie_id, g.id, g.genre
+		FROM
+			movies_genres AS mg
+		JOIN genres AS g on (mg.genre_id = g.id)
+		WHERE
+			mg.movie_id IN (%s)
+		ORDER BY g.genre
+	`,
+		inList(&args, movieIds),
+	)
 
-	_, err := r.Db.ExecContext(ctx, stmt, id)
+	rows, err := r.conn().QueryContext(ctx, query, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	for _, n := range genreIds {
-		stmt := `
-			INSERT INTO movies_genres (movie_id, genre_id)
-				VALUES ($1, $2)
-		`
-		_, err := r.Db.ExecContext(ctx, stmt, id, n)
+	for rows.Next() {
+		var movieId int
+		var g models.Genre
+		err := rows.Scan(
+			&movieId,
+			&g.Id,
+			&g.Genre,
+		)
 		if err != nil {
-			return err
+			return nil, err
 		}
+
+		genres[movieId] = append(genres[movieId], &g)
 	}
 
-	return nil
+	return genres, nil
+}
+
+// MoviesForGenres loads the same page of movies, ordered by title, for
+// each of many genres in one query
+func (r *PostgresDbRepo) MoviesForGenres(genreIds []int, page, limit int) (map[int][]*models.Movie, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	movies := make(map[int][]*models.Movie)
+	if len(genreIds) == 0 {
+		return movies, nil
+	}
+
+	var args []any
+	in := inList(&args, genreIds)
+	args = append(args, (page-1)*limit, page*limit)
+
+	// number the movies of each genre, then cut out the page
+	query := fmt.Sprintf(`
+		SELECT
+			genre_id, id, title, release_date, runtime, mpaa_rating, description, image, created_at, updated_at
+		FROM (
+			SELECT
+				mg.genre_id, m.id, m.title, m.release_date, m.runtime, m.mpaa_rating, m.description,
+				coalesce(m.image, '') AS image, m.created_at, m.updated_at,
+				ROW_NUMBER() OVER (PARTITION BY mg.genre_id ORDER BY m.title, m.id) AS n
+			FROM
+				movies_genres AS mg
+			JOIN movies AS m on (mg.movie_id = m.id)
+			WHERE
+				mg.genre_id IN (%s)
+		) AS numbered
+		WHERE n > $%d AND n <= $%d
+		ORDER BY genre_id, n
+	`,
+		in,
+		len(args)-1,
+		len(args),
+	)
+
+	rows, err := r.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var genreId int
+		var movie models.Movie
+		err := rows.Scan(
+			&genreId,
+			&movie.Id,
+			&movie.Title,
+			&movie.ReleaseDate,
+			&movie.RunTime,
+			&movie.MpaaRating,
+			&movie.Description,
+			&movie.Image,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies[genreId] = append(movies[genreId], &movie)
+	}
+
+	return movies, nil
+}
+
+// MovieRating returns the average rating of a movie. Movies without
+// reviews have a count of 0.
+func (r *PostgresDbRepo) MovieRating(movieId int) (*models.RatingSummary, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT
+			coalesce(avg(rating), 0), count(*)
+		FROM
+			reviews
+		WHERE
+			movie_id = $1
+	`
+
+	var summary models.RatingSummary
+
+	err := r.conn().QueryRowContext(ctx, query, movieId).Scan(&summary.Average, &summary.Count)
+	if err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
+}
+
+// ReviewsForMovie returns a page of the reviews of a movie, newest first,
+// and the number of reviews
+func (r *PostgresDbRepo) ReviewsForMovie(movieId, page, limit int) ([]*models.Review, int, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT
+			rv.id, rv.movie_id, rv.user_id, u.first_name, rv.rating, rv.body,
+			rv.created_at, rv.updated_at, count(*) OVER ()
+		FROM
+			reviews AS rv
+		JOIN users AS u on (rv.user_id = u.id)
+		WHERE
+			rv.movie_id = $1
+		ORDER BY rv.created_at DESC, rv.id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.conn().QueryContext(ctx, query, movieId, limit, (page-1)*limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var reviews []*models.Review
+	total := 0
+
+	for rows.Next() {
+		var review models.Review
+		err := rows.Scan(
+			&review.Id,
+			&review.MovieId,
+			&review.UserId,
+			&review.Author,
+			&review.Rating,
+			&review.Body,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&total,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// past the last page there are no rows to carry the total
+	if len(reviews) == 0 && page > 1 {
+		err = r.conn().QueryRowContext(ctx, `SELECT count(*) FROM reviews WHERE movie_id = $1`, movieId).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return reviews, total, nil
+}
+
+func (r *PostgresDbRepo) OneReview(id int) (*models.Review, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT
+			rv.id, rv.movie_id, rv.user_id, u.first_name, rv.rating, rv.body, rv.created_at, rv.updated_at
+		FROM
+			reviews AS rv
+		JOIN users AS u on (rv.user_id = u.id)
+		WHERE
+			rv.id = $1
+	`
+
+	var review models.Review
+
+	row := r.conn().QueryRowContext(ctx, query, id)
+
+	err := row.Scan(
+		&review.Id,
+		&review.MovieId,
+		&review.UserId,
+		&review.Author,
+		&review.Rating,
+		&review.Body,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+// InsertReview adds a review. A second review of the same movie by the same
+// user gives models.ErrDuplicateReview.
+func (r *PostgresDbRepo) InsertReview(review models.Review) (int, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO reviews
+			(movie_id, user_id, rating, body, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		`
+
+	var newId int
+
+	err := r.conn().QueryRowContext(
+		ctx,
+		stmt,
+		review.MovieId,
+		review.UserId,
+		review.Rating,
+		review.Body,
+		review.CreatedAt,
+		review.UpdatedAt,
+	).Scan(&newId)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, models.ErrDuplicateReview
+		}
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+func (r *PostgresDbRepo) UpdateReview(review models.Review) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE reviews SET
+			rating = $1,
+			body = $2,
+			updated_at = $3
+		WHERE id = $4
+	`
+
+	_, err := r.conn().ExecContext(ctx, stmt, review.Rating, review.Body, review.UpdatedAt, review.Id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) DeleteReview(id int) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `DELETE FROM reviews WHERE id = $1`
+
+	_, err := r.conn().ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) AllPeople() ([]*models.Person, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT
+			id, name, birthday, biography, coalesce(image, ''), created_at, updated_at
+		FROM
+			people
+		ORDER BY name, id
+	`
+
+	rows, err := r.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var people []*models.Person
+
+	for rows.Next() {
+		var person models.Person
+		err := rows.Scan(
+			&person.Id,
+			&person.Name,
+			&person.Birthday,
+			&person.Biography,
+			&person.Image,
+			&person.CreatedAt,
+			&person.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return people, nil
+}
+
+// OnePerson returns a person with their filmography, newest movie first
+func (r *PostgresDbRepo) OnePerson(id int) (*models.Person, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT
+			id, name, birthday, biography, coalesce(image, ''), created_at, updated_at
+		FROM
+			people
+		WHERE
+			id = $1
+	`
+
+	var person models.Person
+
+	row := r.conn().QueryRowContext(ctx, query, id)
+
+	err := row.Scan(
+		&person.Id,
+		&person.Name,
+		&person.Birthday,
+		&person.Biography,
+		&person.Image,
+		&person.CreatedAt,
+		&person.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// get filmography
+	query = `
+		SELECT
+			c.id, c.movie_id, c.person_id, c.type, c.character, c.billing,
+			m.id, m.title, m.release_date, m.runtime, m.mpaa_rating, m.description,
+			coalesce(m.image, ''), m.created_at, m.updated_at
+		FROM
+			credits AS c
+		JOIN movies AS m on (c.movie_id = m.id)
+		WHERE
+			c.person_id = $1
+		ORDER BY m.release_date DESC, m.title, c.billing
+	`
+
+	rows, err := r.conn().QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var credit models.Credit
+		var movie models.Movie
+		err := rows.Scan(
+			&credit.Id,
+			&credit.MovieId,
+			&credit.PersonId,
+			&credit.Type,
+			&credit.Character,
+			&credit.Billing,
+			&movie.Id,
+			&movie.Title,
+			&movie.ReleaseDate,
+			&movie.RunTime,
+			&movie.MpaaRating,
+			&movie.Description,
+			&movie.Image,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credit.Movie = &movie
+		person.Credits = append(person.Credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &person, nil
+}
+
+func (r *PostgresDbRepo) InsertPerson(person models.Person) (int, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO people
+			(name, birthday, biography, image, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		`
+
+	var newId int
+
+	err := r.conn().QueryRowContext(
+		ctx,
+		stmt,
+		person.Name,
+		person.Birthday,
+		person.Biography,
+		person.Image,
+		person.CreatedAt,
+		person.UpdatedAt,
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+func (r *PostgresDbRepo) UpdatePerson(person models.Person) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE people SET
+			name = $1,
+			birthday = $2,
+			biography = $3,
+			image = $4,
+			updated_at = $5
+		WHERE id = $6
+	`
+
+	_, err := r.conn().ExecContext(
+		ctx,
+		stmt,
+		person.Name,
+		person.Birthday,
+		person.Biography,
+		person.Image,
+		person.UpdatedAt,
+		person.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) DeletePerson(id int) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `DELETE FROM people WHERE id = $1`
+
+	_, err := r.conn().ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) InsertCredit(credit models.Credit) (int, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO credits
+			(movie_id, person_id, type, character, billing)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id
+		`
+
+	var newId int
+
+	err := r.conn().QueryRowContext(
+		ctx,
+		stmt,
+		credit.MovieId,
+		credit.PersonId,
+		credit.Type,
+		credit.Character,
+		credit.Billing,
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// UpdateCredit changes a credit of a person. It returns false if the person
+// has no such credit.
+func (r *PostgresDbRepo) UpdateCredit(credit models.Credit) (bool, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE credits SET
+			movie_id = $1,
+			type = $2,
+			character = $3,
+			billing = $4
+		WHERE id = $5 AND person_id = $6
+	`
+
+	result, err := r.conn().ExecContext(
+		ctx,
+		stmt,
+		credit.MovieId,
+		credit.Type,
+		credit.Character,
+		credit.Billing,
+		credit.Id,
+		credit.PersonId,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// DeleteCredit removes a credit of a person. It returns false if the person
+// has no such credit.
+func (r *PostgresDbRepo) DeleteCredit(personId, creditId int) (bool, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `DELETE FROM credits WHERE id = $1 AND person_id = $2`
+
+	result, err := r.conn().ExecContext(ctx, stmt, creditId, personId)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// ListEntries returns the movies on a user's list, in list order
+func (r *PostgresDbRepo) ListEntries(userId int, list string) ([]*models.ListEntry, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT
+			m.id, m.title, m.release_date, m.runtime, m.mpaa_rating, m.description,
+			coalesce(m.image, ''), m.created_at, m.updated_at,
+			le.position, le.watched_at, le.created_at
+		FROM
+			list_entries AS le
+		JOIN movies AS m on (le.movie_id = m.id)
+		WHERE
+			le.user_id = $1 AND le.list = $2
+		ORDER BY le.position, le.id
+	`
+
+	rows, err := r.conn().QueryContext(ctx, query, userId, list)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*models.ListEntry
+
+	for rows.Next() {
+		var entry models.ListEntry
+		err := rows.Scan(
+			&entry.Id,
+			&entry.Title,
+			&entry.ReleaseDate,
+			&entry.RunTime,
+			&entry.MpaaRating,
+			&entry.Description,
+			&entry.Image,
+			&entry.CreatedAt,
+			&entry.UpdatedAt,
+			&entry.Position,
+			&entry.WatchedAt,
+			&entry.AddedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// AddListEntry puts a movie at the end of a user's list. It returns false
+// if the movie was on the list already.
+func (r *PostgresDbRepo) AddListEntry(userId int, list string, movieId int) (bool, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO list_entries
+			(user_id, list, movie_id, position, created_at)
+			SELECT $1, $2, $3, coalesce(max(position), 0) + 1, $4
+			FROM list_entries
+			WHERE user_id = $1 AND list = $2
+		ON CONFLICT (user_id, list, movie_id) DO NOTHING
+	`
+
+	result, err := r.conn().ExecContext(ctx, stmt, userId, list, movieId, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// RemoveListEntry takes a movie off a user's list. It returns false if the
+// movie was not on the list.
+func (r *PostgresDbRepo) RemoveListEntry(userId int, list string, movieId int) (bool, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `DELETE FROM list_entries WHERE user_id = $1 AND list = $2 AND movie_id = $3`
+
+	result, err := r.conn().ExecContext(ctx, stmt, userId, list, movieId)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// ReorderList puts the movies of a user's list in the given order. The ids
+// must be exactly the movies on the list, or models.ErrInvalidOrder is
+// returned and the list is left as it was.
+func (r *PostgresDbRepo) ReorderList(userId int, list string, movieIds []int) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	return r.WithTx(func(repo repository.DatabaseRepo) error {
+		tx := repo.(*PostgresDbRepo).conn()
+
+		// lock the entries, so that the check holds until the update
+		rows, err := tx.QueryContext(ctx,
+			`SELECT movie_id FROM list_entries WHERE user_id = $1 AND list = $2 FOR UPDATE`,
+			userId, list,
+		)
+		if err != nil {
+			return err
+		}
+
+		current := make(map[int]bool)
+		for rows.Next() {
+			var movieId int
+			err := rows.Scan(&movieId)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			current[movieId] = true
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if !sameIds(current, movieIds) {
+			return models.ErrInvalidOrder
+		}
+
+		stmt := `UPDATE list_entries SET position = $1 WHERE user_id = $2 AND list = $3 AND movie_id = $4`
+		for i, movieId := range movieIds {
+			_, err = tx.ExecContext(ctx, stmt, i+1, userId, list, movieId)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// SetListEntryWatched records when the movie on a user's list was watched;
+// nil marks it unwatched. It returns false if the movie is not on the list.
+func (r *PostgresDbRepo) SetListEntryWatched(userId int, list string, movieId int, watchedAt *time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `UPDATE list_entries SET watched_at = $1 WHERE user_id = $2 AND list = $3 AND movie_id = $4`
+
+	result, err := r.conn().ExecContext(ctx, stmt, watchedAt, userId, list, movieId)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (r *PostgresDbRepo) GetUserByEmail(email string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT
+			id, email, first_name, last_name, password, role, email_verified_at, created_at, updated_at
+		FROM
+			users
+		WHERE
+			lower(email) = lower($1)
+	`
+
+	var user models.User
+
+	row := r.conn().QueryRowContext(ctx, query, email)
+
+	err := row.Scan(
+		&user.Id,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.Role,
+		&user.EmailVerifiedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *PostgresDbRepo) GetUserById(id int) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT
+			id, email, first_name, last_name, password, role, email_verified_at, created_at, updated_at
+		FROM
+			users
+		WHERE
+			id = $1
+	`
+
+	var user models.User
+
+	row := r.conn().QueryRowContext(ctx, query, id)
+
+	err := row.Scan(
+		&user.Id,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.Role,
+		&user.EmailVerifiedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *PostgresDbRepo) InsertUser(user models.User) (int, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO users
+			(first_name, last_name, email, password, role, email_verified_at, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id
+		`
+
+	var newId int
+
+	err := r.conn().QueryRowContext(
+		ctx,
+		stmt,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.Password,
+		user.Role,
+		user.EmailVerifiedAt,
+		user.CreatedAt,
+		user.UpdatedAt,
+	).Scan(&newId)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, models.ErrDuplicateEmail
+		}
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+func (r *PostgresDbRepo) VerifyUser(id int) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE users SET
+			email_verified_at = $1,
+			updated_at = $1
+		WHERE id = $2 AND email_verified_at IS NULL
+	`
+
+	_, err := r.conn().ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) UpdateUserPassword(id int, hash string) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE users SET
+			password = $1,
+			updated_at = $2
+		WHERE id = $3
+	`
+
+	_, err := r.conn().ExecContext(ctx, stmt, hash, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) UpdateUserRole(id int, role string) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE users SET
+			role = $1,
+			updated_at = $2
+		WHERE id = $3
+	`
+
+	_, err := r.conn().ExecContext(ctx, stmt, role, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) InsertUserToken(token models.UserToken) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO user_tokens
+			(user_id, token_hash, scope, expires_at, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`
+
+	_, err := r.conn().ExecContext(
+		ctx,
+		stmt,
+		token.UserId,
+		token.TokenHash,
+		token.Scope,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns it.
+// Unknown, used and expired tokens all give sql.ErrNoRows.
+func (r *PostgresDbRepo) ConsumeUserToken(hash, scope string) (*models.UserToken, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE user_tokens SET
+			used_at = $1
+		WHERE
+			token_hash = $2 AND scope = $3 AND used_at IS NULL AND expires_at > $1
+		RETURNING
+			id, user_id, token_hash, scope, expires_at, used_at, created_at
+	`
+
+	var token models.UserToken
+
+	row := r.conn().QueryRowContext(ctx, stmt, time.Now(), hash, scope)
+
+	err := row.Scan(
+		&token.Id,
+		&token.UserId,
+		&token.TokenHash,
+		&token.Scope,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *PostgresDbRepo) DeleteUserTokens(userId int, scope string) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		DELETE FROM user_tokens
+		WHERE user_id = $1 AND scope = $2
+	`
+
+	_, err := r.conn().ExecContext(ctx, stmt, userId, scope)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) InsertRefreshToken(token models.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO refresh_tokens
+			(user_id, token_hash, family_id, expires_at, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`
+
+	_, err := r.conn().ExecContext(
+		ctx,
+		stmt,
+		token.UserId,
+		token.TokenHash,
+		token.FamilyId,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT
+			id, user_id, token_hash, family_id, expires_at, revoked_at, created_at
+		FROM
+			refresh_tokens
+		WHERE
+			token_hash = $1
+	`
+
+	var token models.RefreshToken
+
+	row := r.conn().QueryRowContext(ctx, query, hash)
+
+	err := row.Scan(
+		&token.Id,
+		&token.UserId,
+		&token.TokenHash,
+		&token.FamilyId,
+		&token.ExpiresAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// RevokeRefreshToken revokes a single token, and reports whether this call
+// did so. False means the token was already revoked, e.g. by a concurrent
+// refresh using the same token.
+func (r *PostgresDbRepo) RevokeRefreshToken(id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE refresh_tokens SET
+			revoked_at = $1
+		WHERE id = $2 AND revoked_at IS NULL
+	`
+
+	result, err := r.conn().ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (r *PostgresDbRepo) RevokeRefreshTokenFamily(familyId string) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE refresh_tokens SET
+			revoked_at = $1
+		WHERE family_id = $2 AND revoked_at IS NULL
+	`
+
+	_, err := r.conn().ExecContext(ctx, stmt, time.Now(), familyId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) RevokeUserRefreshTokens(userId int) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE refresh_tokens SET
+			revoked_at = $1
+		WHERE user_id = $2 AND revoked_at IS NULL
+	`
+
+	_, err := r.conn().ExecContext(ctx, stmt, time.Now(), userId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) InsertMovie(movie models.Movie) (int, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO movies
+			(title, description, release_date, runtime, mpaa_rating, created_at, updated_at, image)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING ID
+		`
+
+	var newId int
+
+	err := r.conn().QueryRowContext(
+		ctx,
+		stmt,
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate,
+		movie.RunTime,
+		movie.MpaaRating,
+		movie.CreatedAt,
+		movie.UpdatedAt,
+		movie.Image,
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+func (r *PostgresDbRepo) UpdateMovie(movie models.Movie) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE movies SET
+			title = $1,
+			description = $2,
+			release_date = $3,
+			runtime = $4,
+			mpaa_rating = $5,
+			updated_at = $6,
+			image = $7,
+			version = version + 1
+		WHERE id = $8 AND version = $9
+	`
+
+	result, err := r.conn().ExecContext(
+		ctx,
+		stmt,
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate,
+		movie.RunTime,
+		movie.MpaaRating,
+		movie.UpdatedAt,
+		movie.Image,
+		movie.Id,
+		movie.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	return editConflict(result)
+}
+
+func (r *PostgresDbRepo) DeleteMovie(id, version int) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	stmt := `
+		DELETE FROM movies
+		WHERE id = $1 AND version = $2
+	`
+	// genres are taken care of by the Postgres foreign key
+	result, err := r.conn().ExecContext(ctx, stmt, id, version)
+	if err != nil {
+		return err
+	}
+
+	return editConflict(result)
+}
+
+// editConflict returns models.ErrEditConflict when a write guarded by a
+// version matched no rows
+func editConflict(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrEditConflict
+	}
+
+	return nil
+}
+
+func (r *PostgresDbRepo) UpdateMovieGenres(id int, genreIds []int) error {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	// replace the rows in one transaction, so a failure keeps the old genres
+	return r.WithTx(func(repo repository.DatabaseRepo) error {
+		tx := repo.(*PostgresDbRepo).conn()
+
+		stmt := `
+			DELETE FROM movies_genres
+			WHERE movie_id = $1
+		`
+
+		_, err := tx.ExecContext(ctx, stmt, id)
+		if err != nil {
+			return err
+		}
+
+		// the genres are part of the movie, so changing them is a new version
+		_, err = tx.ExecContext(ctx, `UPDATE movies SET version = version + 1 WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range genreIds {
+			stmt := `
+				INSERT INTO movies_genres (movie_id, genre_id)
+					VALUES ($1, $2)
+			`
+			_, err := tx.ExecContext(ctx, stmt, id, n)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *PostgresDbRepo) FilterMovies(q models.MovieQuery) (*models.MoviePage, error) {
+	ctx, cancel := context.WithTimeout(r.parentContext(), dbTimeout)
+	defer cancel()
+
+	var conditions []string
+	var args []any
+
+	// arg adds a query argument and returns its placeholder
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.TitleContains != "" {
+		// match the text literally, not as a LIKE pattern
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q.TitleContains)
+		conditions = append(conditions, "title ILIKE "+arg("%"+escaped+"%"))
+	}
+
+	if len(q.Ratings) > 0 {
+		var placeholders []string
+		for _, rating := range q.Ratings {
+			placeholders = append(placeholders, arg(rating))
+		}
+		conditions = append(conditions, fmt.Sprintf("mpaa_rating IN (%s)", strings.Join(placeholders, ", ")))
+	}
+
+	if q.YearFrom > 0 {
+		conditions = append(conditions, "release_date >= "+arg(time.Date(q.YearFrom, 1, 1, 0, 0, 0, 0, time.UTC)))
+	}
+	if q.YearTo > 0 {
+		conditions = append(conditions, "release_date < "+arg(time.Date(q.YearTo+1, 1, 1, 0, 0, 0, 0, time.UTC)))
+	}
+
+	if q.RunTimeMin > 0 {
+		conditions = append(conditions, "runtime >= "+arg(q.RunTimeMin))
+	}
+	if q.RunTimeMax > 0 {
+		conditions = append(conditions, "runtime <= "+arg(q.RunTimeMax))
+	}
+
+	if len(q.Genres) > 0 {
+		var placeholders []string
+		for _, genreId := range q.Genres {
+			placeholders = append(placeholders, arg(genreId))
+		}
+		in := strings.Join(placeholders, ", ")
+
+		if q.MatchAllGenres {
+			conditions = append(conditions, fmt.Sprintf(
+				"(SELECT COUNT(DISTINCT genre_id) FROM movies_genres WHERE movie_id = movies.id AND genre_id IN (%s)) = %s",
+				in,
+				arg(len(q.Genres)),
+			))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("id IN (SELECT movie_id FROM movies_genres WHERE genre_id IN (%s))", in))
+		}
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// count every matching row, ignoring the page
+	var total int
+	err := r.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM movies "+where, args...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sort column is checked against a fixed list, so is safe to interpolate
+	column := models.SortTitle
+	switch q.Sort {
+	case models.SortReleaseDate, models.SortRunTime, models.SortCreatedAt:
+		column = q.Sort
+	}
+
+	// a "before" cursor walks the listing backwards, and is flipped back afterwards
+	desc := q.Desc
+	if q.Cursor != nil && q.Cursor.Before {
+		desc = !desc
+	}
+
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if q.Cursor != nil {
+		value, err := q.CursorValue()
+		if err != nil {
+			return nil, err
+		}
+
+		keyset := fmt.Sprintf("(%s, id) %s (%s, %s)", column, comparison, arg(value), arg(q.Cursor.Id))
+		if where == "" {
+			where = "WHERE " + keyset
+		} else {
+			where += " AND " + keyset
+		}
+	}
+
+	// fetch one extra row to find out whether there is another page
+	query := fmt.Sprintf(`
+		SELECT
+			id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at
+		FROM
+			movies
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT %s OFFSET %s
+		`,
+		where,
+		column, direction, direction,
+		arg(q.Limit+1),
+		arg(q.Offset()),
+	)
+
+	rows, err := r.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movies []*models.Movie
+
+	for rows.Next() {
+		var movie models.Movie
+		err := rows.Scan(
+			&movie.Id,
+			&movie.Title,
+			&movie.ReleaseDate,
+			&movie.RunTime,
+			&movie.MpaaRating,
+			&movie.Description,
+			&movie.Image,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	return newMoviePage(q, movies, total), nil
+}
+
+// inList adds ids to the query arguments and returns their placeholders,
+// for use in an IN (...) clause
+func inList(args *[]any, ids []int) string {
+	var placeholders []string
+	for _, id := range ids {
+		*args = append(*args, id)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(*args)))
+	}
+
+	return strings.Join(placeholders, ", ")
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// sameIds reports whether ids holds each id of current exactly once
+func sameIds(current map[int]bool, ids []int) bool {
+	if len(ids) != len(current) {
+		return false
+	}
+
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		if !current[id] || seen[id] {
+			return false
+		}
+		seen[id] = true
+	}
+
+	return true
 }
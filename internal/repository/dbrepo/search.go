@@ -0,0 +1,144 @@
+package dbrepo
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	// similarity above which a title counts as a match despite typos,
+	// the same as the pg_trgm default for the % operator
+	trigramThreshold = 0.3
+
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+
+	// ts_headline marks matches with these instead of the tags, as they
+	// can't be mistaken for markup in the text. They become the tags once
+	// the text is HTML escaped.
+	headlineStart = "\x01"
+	headlineStop  = "\x02"
+)
+
+// searchTerms splits a search string into lower case words, dropping
+// punctuation so the terms are safe to use in a tsquery
+func searchTerms(search string) []string {
+	return strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// prefixQuery builds a tsquery matching every term as a word prefix
+func prefixQuery(terms []string) string {
+	var parts []string
+	for _, term := range terms {
+		parts = append(parts, term+":*")
+	}
+
+	return strings.Join(parts, " & ")
+}
+
+// trigrams returns the set of trigrams of a string, as pg_trgm extracts them
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range searchTerms(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+
+	return set
+}
+
+// similarity matches pg_trgm's similarity(): shared trigrams over all trigrams
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+// matchingWords counts, for each term, the words of text that start with it
+func matchingWords(text string, terms []string) map[string]int {
+	hits := make(map[string]int)
+	for _, word := range searchTerms(text) {
+		for _, term := range terms {
+			if strings.HasPrefix(word, term) {
+				hits[term]++
+			}
+		}
+	}
+
+	return hits
+}
+
+// headlineHtml turns a ts_headline result into HTML: the text is escaped,
+// so that markup in it is shown rather than run, and then the matches are
+// marked
+func headlineHtml(headline string) string {
+	escaped := html.EscapeString(headline)
+	escaped = strings.ReplaceAll(escaped, headlineStart, highlightStart)
+
+	return strings.ReplaceAll(escaped, headlineStop, highlightStop)
+}
+
+// highlight returns text as HTML, marking the words that start with a term.
+// When maxWords is above zero, the text is cut down to that many words
+// around the first match.
+func highlight(text string, terms []string, maxWords int) string {
+	words := strings.Fields(text)
+	first := -1
+
+	for i, word := range words {
+		matched := false
+		for _, term := range searchTerms(word) {
+			if matchesAny(term, terms) {
+				matched = true
+				break
+			}
+		}
+
+		words[i] = html.EscapeString(word)
+		if matched {
+			words[i] = highlightStart + words[i] + highlightStop
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+
+	if maxWords <= 0 || len(words) <= maxWords {
+		return strings.Join(words, " ")
+	}
+
+	start := first - maxWords/2
+	if start < 0 {
+		start = 0
+	}
+	if start+maxWords > len(words) {
+		start = len(words) - maxWords
+	}
+
+	return strings.Join(words[start:start+maxWords], " ")
+}
+
+func matchesAny(word string, terms []string) bool {
+	for _, term := range terms {
+		if strings.HasPrefix(word, term) {
+			return true
+		}
+	}
+
+	return false
+}
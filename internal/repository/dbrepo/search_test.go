@@ -0,0 +1,12 @@
+package dbrepo
+
+import "testing"
+
+func TestHeadlineHtml(t *testing.T) {
+	headline := "Tom \x01&\x02 <b>Jerry</b>'s \x01\"chase\"\x02"
+	want := `Tom <mark>&amp;</mark> &lt;b&gt;Jerry&lt;/b&gt;&#39;s <mark>&#34;chase&#34;</mark>`
+
+	if got := headlineHtml(headline); got != want {
+		t.Errorf("headlineHtml() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// fixture is the layout of the seed file, e.g. sql/seed.json. Genres are
+// referred to by their id in the file from the genres_array of movies.
+type fixture struct {
+	Genres []models.Genre `json:"genres"`
+	Movies []models.Movie `json:"movies"`
+	Users  []models.User  `json:"users"`
+}
+
+func readFixture(path string) (*fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f fixture
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// Seed loads the genres, movies and users of a seed file into an empty
+// repository, in one transaction. Records get new ids; the genres of movies
+// are mapped to them. Users are verified and can log in straight away, so
+// this is for development databases only.
+func Seed(repo repository.DatabaseRepo, path string) error {
+	f, err := readFixture(path)
+	if err != nil {
+		return err
+	}
+
+	return repo.WithTx(func(repo repository.DatabaseRepo) error {
+		genres, err := repo.AllGenres()
+		if err != nil {
+			return err
+		}
+		if len(genres) > 0 {
+			return errors.New("the database already has data")
+		}
+
+		now := time.Now()
+
+		genreIds := make(map[int]int)
+		for _, g := range f.Genres {
+			g.CreatedAt, g.UpdatedAt = now, now
+			newId, err := repo.InsertGenre(g)
+			if err != nil {
+				return fmt.Errorf("genre %q: %w", g.Genre, err)
+			}
+			genreIds[g.Id] = newId
+		}
+
+		for _, m := range f.Movies {
+			var ids []int
+			for _, id := range m.GenresArray {
+				newId, ok := genreIds[id]
+				if !ok {
+					return fmt.Errorf("movie %q: unknown genre %d", m.Title, id)
+				}
+				ids = append(ids, newId)
+			}
+
+			m.CreatedAt, m.UpdatedAt = now, now
+			newId, err := repo.InsertMovie(m)
+			if err != nil {
+				return fmt.Errorf("movie %q: %w", m.Title, err)
+			}
+
+			err = repo.UpdateMovieGenres(newId, ids)
+			if err != nil {
+				return fmt.Errorf("movie %q: %w", m.Title, err)
+			}
+		}
+
+		for _, u := range f.Users {
+			if u.Role == "" {
+				u.Role = models.RoleViewer
+			}
+			u.EmailVerifiedAt = &now
+			u.CreatedAt, u.UpdatedAt = now, now
+			_, err := repo.InsertUser(u)
+			if err != nil {
+				return fmt.Errorf("user %s: %w", u.Email, err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -2,22 +2,78 @@ package repository
 
 import (
 	"backend/internal/models"
+	"context"
 	"database/sql"
+	"time"
 )
 
 type DatabaseRepo interface {
 	Connection() *sql.DB
+	WithContext(ctx context.Context) DatabaseRepo
+
+	// WithTx runs fn as a unit of work: everything fn does through the
+	// repository it is given commits if fn returns nil, and rolls back if it
+	// returns an error or panics. Calls within a transaction join it.
+	WithTx(fn func(repo DatabaseRepo) error) error
 
 	AllMovies(genre ...int) ([]*models.Movie, error)
+	FilterMovies(q models.MovieQuery) (*models.MoviePage, error)
+	SearchMovies(search string, limit int) ([]*models.MovieSearchResult, error)
 	OneMovie(id int) (*models.Movie, error)
 	OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error)
 	InsertMovie(movie models.Movie) (int, error)
+	// UpdateMovie and DeleteMovie only write a movie that is still at the
+	// version it was read at, and return models.ErrEditConflict otherwise.
+	// Every write to a movie or its genres increments its version.
 	UpdateMovie(movie models.Movie) error
 	UpdateMovieGenres(id int, genreIds []int) error
-	DeleteMovie(id int) error
+	DeleteMovie(id, version int) error
 
 	AllGenres() ([]*models.Genre, error)
+	OneGenre(id int) (*models.Genre, error)
+	InsertGenre(genre models.Genre) (int, error)
+	UpdateGenre(genre models.Genre) error
+	DeleteGenre(id int) error
+	MergeGenres(fromId, intoId int) error
+	GenresForMovies(movieIds []int) (map[int][]*models.Genre, error)
+	MoviesForGenres(genreIds []int, page, limit int) (map[int][]*models.Movie, error)
+
+	MovieRating(movieId int) (*models.RatingSummary, error)
+	ReviewsForMovie(movieId, page, limit int) ([]*models.Review, int, error)
+	OneReview(id int) (*models.Review, error)
+	InsertReview(review models.Review) (int, error)
+	UpdateReview(review models.Review) error
+	DeleteReview(id int) error
+
+	AllPeople() ([]*models.Person, error)
+	OnePerson(id int) (*models.Person, error)
+	InsertPerson(person models.Person) (int, error)
+	UpdatePerson(person models.Person) error
+	DeletePerson(id int) error
+	InsertCredit(credit models.Credit) (int, error)
+	UpdateCredit(credit models.Credit) (bool, error)
+	DeleteCredit(personId, creditId int) (bool, error)
+
+	ListEntries(userId int, list string) ([]*models.ListEntry, error)
+	AddListEntry(userId int, list string, movieId int) (bool, error)
+	RemoveListEntry(userId int, list string, movieId int) (bool, error)
+	ReorderList(userId int, list string, movieIds []int) error
+	SetListEntryWatched(userId int, list string, movieId int, watchedAt *time.Time) (bool, error)
 
 	GetUserByEmail(email string) (*models.User, error)
 	GetUserById(id int) (*models.User, error)
+	InsertUser(user models.User) (int, error)
+	VerifyUser(id int) error
+	UpdateUserPassword(id int, hash string) error
+	UpdateUserRole(id int, role string) error
+
+	InsertUserToken(token models.UserToken) error
+	ConsumeUserToken(hash, scope string) (*models.UserToken, error)
+	DeleteUserTokens(userId int, scope string) error
+
+	InsertRefreshToken(token models.RefreshToken) error
+	GetRefreshTokenByHash(hash string) (*models.RefreshToken, error)
+	RevokeRefreshToken(id int) (bool, error)
+	RevokeRefreshTokenFamily(familyId string) error
+	RevokeUserRefreshTokens(userId int) error
 }
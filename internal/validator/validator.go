@@ -0,0 +1,48 @@
+package validator
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// Validator collects errors for the fields of a request, keyed by field name
+type Validator struct {
+	Errors map[string]string
+}
+
+func New() *Validator {
+	return &Validator{Errors: make(map[string]string)}
+}
+
+// Valid reports whether no errors were added
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// AddError adds an error for a field, keeping the first one if there are several
+func (v *Validator) AddError(field, message string) {
+	if _, exists := v.Errors[field]; !exists {
+		v.Errors[field] = message
+	}
+}
+
+// Check adds an error for a field when ok is false
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.AddError(field, message)
+	}
+}
+
+func NotBlank(value string) bool {
+	return strings.TrimSpace(value) != ""
+}
+
+func MaxLength(value string, n int) bool {
+	return len([]rune(value)) <= n
+}
+
+// IsEmail reports whether value is a bare email address, without a display name
+func IsEmail(value string) bool {
+	addr, err := mail.ParseAddress(value)
+	return err == nil && addr.Address == value
+}